@@ -0,0 +1,248 @@
+package vxlan
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+//Agent answers l2miss and l3miss notifications on the host's vxlan interfaces from the shared node records,
+//so vxlans can run with learning disabled and forward deterministically without flooding
+type Agent struct {
+	RecordsPath    string
+	ResyncInterval time.Duration
+	node           string
+}
+
+//NewAgent returns a new Agent reading node records from recordsPath
+func NewAgent(recordsPath string, resyncInterval time.Duration) (*Agent, error) {
+	node, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		RecordsPath:    recordsPath,
+		ResyncInterval: resyncInterval,
+		node:           node,
+	}, nil
+}
+
+//Run handles neighbor misses until done is closed, periodically removing entries the records no longer support
+//it returns an error if the neighbor subscription ends before then
+func (a *Agent) Run(done <-chan struct{}) error {
+	ch := make(chan netlink.NeighUpdate)
+	err := netlink.NeighSubscribeWithOptions(ch, done, netlink.NeighSubscribeOptions{
+		ErrorCallback: func(err error) {
+			log.WithError(err).Errorf("neighbor subscription error")
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(a.ResyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			err = a.Resync()
+			if err != nil {
+				log.WithError(err).Errorf("failed to resync neighbor tables")
+			}
+		case u, ok := <-ch:
+			if !ok {
+				//the subscription also closes the channel on shutdown
+				select {
+				case <-done:
+					return nil
+				default:
+					return fmt.Errorf("neighbor subscription closed")
+				}
+			}
+			if u.Type != unix.RTM_GETNEIGH {
+				continue
+			}
+			err = a.handleMiss(u.Neigh)
+			if err != nil {
+				log.WithError(err).Errorf("failed to handle neighbor miss")
+			}
+		}
+	}
+}
+
+func (a *Agent) handleMiss(n netlink.Neigh) error {
+	network, ok := vxlanNetwork(n.LinkIndex)
+	if !ok {
+		return nil
+	}
+
+	records, err := a.remoteRecords(network)
+	if err != nil {
+		return err
+	}
+
+	if n.IP != nil && n.Family != unix.AF_BRIDGE {
+		//l3miss, the kernel wants the mac for an ip
+		log.WithFields(log.Fields{"network": network, "ip": n.IP}).Debugf("l3miss")
+		rec, entry := findNeighbor(records, "", n.IP.String())
+		if entry == nil {
+			return nil
+		}
+
+		mac, err := net.ParseMAC(entry.MAC)
+		if err != nil {
+			return err
+		}
+
+		err = netlink.NeighSet(&netlink.Neigh{
+			LinkIndex:    n.LinkIndex,
+			Family:       n.Family,
+			State:        netlink.NUD_REACHABLE,
+			IP:           n.IP,
+			HardwareAddr: mac,
+		})
+		if err != nil {
+			return err
+		}
+
+		return setFdb(n.LinkIndex, mac, net.ParseIP(rec.Vtep))
+	}
+
+	if n.HardwareAddr != nil {
+		//l2miss, the kernel wants the vtep for a mac
+		log.WithFields(log.Fields{"network": network, "mac": n.HardwareAddr}).Debugf("l2miss")
+		rec, entry := findNeighbor(records, n.HardwareAddr.String(), "")
+		if entry == nil {
+			return nil
+		}
+
+		return setFdb(n.LinkIndex, n.HardwareAddr, net.ParseIP(rec.Vtep))
+	}
+
+	return nil
+}
+
+//Resync removes fdb and neighbor entries on the vxlan interfaces that no longer match the node records
+func (a *Agent) Resync() error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		network, ok := vxlanNetwork(l.Attrs().Index)
+		if !ok {
+			continue
+		}
+
+		records, err := a.remoteRecords(network)
+		if err != nil {
+			return err
+		}
+
+		fdb, err := netlink.NeighList(l.Attrs().Index, unix.AF_BRIDGE)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range fdb {
+			if n.IP == nil || n.State&netlink.NUD_PERMANENT != 0 || n.HardwareAddr.String() == zeroMAC.String() {
+				continue
+			}
+
+			rec, entry := findNeighbor(records, n.HardwareAddr.String(), "")
+			if entry != nil && rec.Vtep == n.IP.String() {
+				continue
+			}
+
+			log.WithFields(log.Fields{"network": network, "mac": n.HardwareAddr, "vtep": n.IP}).Debugf("removing stale fdb entry")
+			err = netlink.NeighDel(&n)
+			if err != nil {
+				log.WithError(err).Errorf("failed to remove stale fdb entry")
+			}
+		}
+
+		neighs, err := netlink.NeighList(l.Attrs().Index, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range neighs {
+			if n.IP == nil || n.Family == unix.AF_BRIDGE || n.State&netlink.NUD_PERMANENT != 0 {
+				continue
+			}
+
+			_, entry := findNeighbor(records, "", n.IP.String())
+			if entry != nil && entry.MAC == n.HardwareAddr.String() {
+				continue
+			}
+
+			log.WithFields(log.Fields{"network": network, "ip": n.IP, "mac": n.HardwareAddr}).Debugf("removing stale neighbor entry")
+			err = netlink.NeighDel(&n)
+			if err != nil {
+				log.WithError(err).Errorf("failed to remove stale neighbor entry")
+			}
+		}
+	}
+
+	return nil
+}
+
+//remoteRecords returns the records for the network published by every node but this one
+func (a *Agent) remoteRecords(network string) ([]*NodeRecord, error) {
+	all, err := LoadNodeRecords(a.RecordsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*NodeRecord
+	for _, r := range all {
+		if r.Network == network && r.Node != a.node {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+func findNeighbor(records []*NodeRecord, mac, ip string) (*NodeRecord, *NeighborRecord) {
+	for _, r := range records {
+		for _, e := range r.Entries {
+			if (mac != "" && e.MAC == mac) || (ip != "" && containsString(e.IPs, ip)) {
+				return r, e
+			}
+		}
+	}
+	return nil, nil
+}
+
+func setFdb(linkIndex int, mac net.HardwareAddr, vtep net.IP) error {
+	return netlink.NeighSet(&netlink.Neigh{
+		LinkIndex:    linkIndex,
+		Family:       unix.AF_BRIDGE,
+		State:        netlink.NUD_REACHABLE,
+		Flags:        netlink.NTF_SELF,
+		IP:           vtep,
+		HardwareAddr: mac,
+	})
+}
+
+//vxlanNetwork returns the network name of a vx_ interface
+func vxlanNetwork(index int) (string, bool) {
+	link, err := netlink.LinkByIndex(index)
+	if err != nil || link.Type() != "vxlan" || !strings.HasPrefix(link.Attrs().Name, VxlanLinkPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(link.Attrs().Name, VxlanLinkPrefix), true
+}
@@ -0,0 +1,236 @@
+package vxlan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+//Bandwidth limits a container's traffic, as passed in the runtime's bandwidth capability
+//rates are in bits per second and bursts in bits, a zero rate is unlimited
+type Bandwidth struct {
+	IngressRate  uint64 `json:"ingressRate"`
+	IngressBurst uint64 `json:"ingressBurst"`
+	EgressRate   uint64 `json:"egressRate"`
+	EgressBurst  uint64 `json:"egressBurst"`
+}
+
+//Merge returns the bandwidth with any direction it leaves unlimited taken from defaults
+func (b *Bandwidth) Merge(defaults *Bandwidth) *Bandwidth {
+	if b == nil {
+		return defaults
+	}
+	if defaults == nil {
+		return b
+	}
+
+	m := *b
+	if m.IngressRate == 0 {
+		m.IngressRate, m.IngressBurst = defaults.IngressRate, defaults.IngressBurst
+	}
+	if m.EgressRate == 0 {
+		m.EgressRate, m.EgressBurst = defaults.EgressRate, defaults.EgressBurst
+	}
+	return &m
+}
+
+//IsLimited reports whether either direction is limited
+func (b *Bandwidth) IsLimited() bool {
+	return b != nil && (b.IngressRate > 0 || b.EgressRate > 0)
+}
+
+//validate returns what is wrong with the bandwidth, or an empty string
+func (b *Bandwidth) validate() string {
+	if b == nil {
+		return ""
+	}
+	if b.IngressBurst > 0 && b.IngressRate == 0 {
+		return "ingressBurst requires ingressRate"
+	}
+	if b.EgressBurst > 0 && b.EgressRate == 0 {
+		return "egressBurst requires egressRate"
+	}
+	return ""
+}
+
+//burst returns the configured burst, or by default DefaultBurstLatency of traffic at the rate, but at least MinBurst
+func burst(rate, burst uint64) uint64 {
+	if burst > 0 {
+		return burst
+	}
+	//multiplied first, so rates below TIME_UNITS_PER_SEC don't truncate to nothing
+	burst = rate * uint64(DefaultBurstLatency) / uint64(netlink.TIME_UNITS_PER_SEC)
+	if burst < MinBurst {
+		burst = MinBurst
+	}
+	return burst
+}
+
+//setTBF replaces the link's root qdisc with a token bucket filter limiting its egress, as the bandwidth plugin does
+func setTBF(link netlink.Link, rateBits, burstBits uint64) error {
+	rate := rateBits / 8
+	buf := uint32(burst(rateBits, burstBits) / 8)
+	//time to send the burst at the rate, in ticks
+	buffer := uint32(float64(buf) * float64(netlink.TIME_UNITS_PER_SEC) / float64(rate) * netlink.TickInUsec())
+	//queue up to DefaultTBFLatency of traffic beyond the burst
+	limit := uint32(float64(rate)*float64(DefaultTBFLatency)/float64(netlink.TIME_UNITS_PER_SEC)) + buf
+
+	log.WithFields(log.Fields{"link": link.Attrs().Name, "rate": rateBits}).Debugf("setting token bucket filter")
+	return netlink.QdiscReplace(&netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rate,
+		Limit:  limit,
+		Buffer: buffer,
+	})
+}
+
+//ifbName returns the name of the ifb device shaping the ingress of the container interface
+//it is derived from the attachment, as TempLinkName is, so interfaces whose names share a prefix get their own
+func ifbName(containerID, ifname string) string {
+	sum := sha256.Sum256([]byte(containerID + "/" + ifname))
+	return IfbLinkPrefix + hex.EncodeToString(sum[:])[:MaxInterfaceName-len(IfbLinkPrefix)]
+}
+
+//applyBandwidth limits the container interface named ifname, it must be called from within the container's namespace
+//egress is shaped on the interface itself, ingress by redirecting it through an ifb device shaped the same way
+//the ifb is named after the attachment rather than the link, which may not have been renamed yet, so removeBandwidth finds it
+func applyBandwidth(link netlink.Link, containerID, ifname string, bw *Bandwidth) (err error) {
+	if bw.EgressRate > 0 {
+		err = setTBF(link, bw.EgressRate, bw.EgressBurst)
+		if err != nil {
+			return err
+		}
+	}
+
+	if bw.IngressRate == 0 {
+		return nil
+	}
+
+	//we hold the network lock, so an ifb with this name is left over from an ADD that failed part way
+	err = removeBandwidth(containerID, ifname)
+	if err != nil {
+		return err
+	}
+
+	ifb := &netlink.Ifb{LinkAttrs: netlink.LinkAttrs{Name: ifbName(containerID, ifname), TxQLen: 1000}}
+	err = netlink.LinkAdd(ifb)
+	if err != nil {
+		return fmt.Errorf("failed to create ifb device: %v", err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if derr := removeBandwidth(containerID, ifname); derr != nil {
+			log.WithError(derr).Errorf("failed to remove ifb device")
+		}
+	}()
+
+	ifbLink, err := netlink.LinkByName(ifb.Name)
+	if err != nil {
+		return err
+	}
+
+	err = netlink.LinkSetUp(ifbLink)
+	if err != nil {
+		return err
+	}
+
+	err = netlink.QdiscReplace(&netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	//match everything and redirect it to the ifb
+	err = netlink.FilterAdd(&netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    netlink.MakeHandle(0xffff, 0),
+			Priority:  1,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		ClassId:    netlink.MakeHandle(1, 1),
+		RedirIndex: ifbLink.Attrs().Index,
+	})
+	if err != nil {
+		return err
+	}
+
+	return setTBF(ifbLink, bw.IngressRate, bw.IngressBurst)
+}
+
+//removeBandwidth removes the ifb device shaping the container interface's ingress, if there is one
+//it must be called from within the container's namespace, the interface's own qdiscs go with it
+func removeBandwidth(containerID, ifname string) error {
+	link, err := netlink.LinkByName(ifbName(containerID, ifname))
+	if err != nil {
+		return nil
+	}
+	return netlink.LinkDel(link)
+}
+
+//resetBandwidth removes any limits applyBandwidth set on the link, it must be called from within the container's namespace
+func resetBandwidth(link netlink.Link, containerID string) error {
+	err := removeBandwidth(containerID, link.Attrs().Name)
+	if err != nil {
+		return err
+	}
+
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return err
+	}
+	for _, q := range qdiscs {
+		if q.Type() != "tbf" && q.Type() != "ingress" {
+			continue
+		}
+		err = netlink.QdiscDel(q)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//applyVxlanBandwidth limits the traffic the network sends into the tunnel, if a limit is configured
+//otherwise a limit set by an earlier config is removed
+func (hi *HostInterface) applyVxlanBandwidth() error {
+	if hi.vxLink == nil {
+		return nil
+	}
+	if hi.VxlanParams.VxlanEgressRate == 0 {
+		return removeTBF(hi.vxLink)
+	}
+	return setTBF(hi.vxLink, hi.VxlanParams.VxlanEgressRate, hi.VxlanParams.VxlanEgressBurst)
+}
+
+//removeTBF removes the root token bucket filter setTBF installed on the link, if there is one
+func removeTBF(link netlink.Link) error {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return err
+	}
+	for _, q := range qdiscs {
+		attrs := q.Attrs()
+		if q.Type() != "tbf" || attrs.Parent != netlink.HANDLE_ROOT {
+			continue
+		}
+		log.WithField("link", link.Attrs().Name).Debugf("removing token bucket filter")
+		return netlink.QdiscDel(q)
+	}
+	return nil
+}
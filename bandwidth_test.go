@@ -0,0 +1,109 @@
+package vxlan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBandwidthMerge(t *testing.T) {
+	defaults := &Bandwidth{IngressRate: 1000000, IngressBurst: 200000, EgressRate: 2000000, EgressBurst: 400000}
+
+	tests := []struct {
+		name     string
+		b        *Bandwidth
+		defaults *Bandwidth
+		want     *Bandwidth
+	}{
+		{"both nil", nil, nil, nil},
+		{"no runtime limits", nil, defaults, defaults},
+		{"no defaults", &Bandwidth{EgressRate: 5}, nil, &Bandwidth{EgressRate: 5}},
+		{"runtime overrides a direction", &Bandwidth{EgressRate: 5000000},
+			defaults, &Bandwidth{IngressRate: 1000000, IngressBurst: 200000, EgressRate: 5000000}},
+		{"runtime overrides both", &Bandwidth{IngressRate: 3, EgressRate: 4, EgressBurst: 8},
+			defaults, &Bandwidth{IngressRate: 3, EgressRate: 4, EgressBurst: 8}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.b.Merge(tt.defaults)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	//merging must not modify the runtime's limits
+	b := &Bandwidth{EgressRate: 5}
+	b.Merge(defaults)
+	if b.IngressRate != 0 {
+		t.Errorf("expected merge to leave its receiver unchanged, got %+v", b)
+	}
+}
+
+func TestBandwidthValidate(t *testing.T) {
+	tests := []struct {
+		b    *Bandwidth
+		want string
+	}{
+		{nil, ""},
+		{&Bandwidth{IngressRate: 1, IngressBurst: 1, EgressRate: 1, EgressBurst: 1}, ""},
+		{&Bandwidth{IngressBurst: 1}, "ingressBurst requires ingressRate"},
+		{&Bandwidth{EgressBurst: 1}, "egressBurst requires egressRate"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.b.validate(); got != tt.want {
+			t.Errorf("validate(%+v) = %q, expected %q", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestBurst(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  uint64
+		burst uint64
+		want  uint64
+	}{
+		{"configured", 1000000000, 12345, 12345},
+		{"derived from the rate", 1000000000, 0, 1000000000 * DefaultBurstLatency / 1000000},
+		{"below a million bits per second", 999999000, 0, 9999990},
+		{"at least the minimum", 1000, 0, MinBurst},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := burst(tt.rate, tt.burst); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIfbName(t *testing.T) {
+	tests := []struct {
+		containerID string
+		ifname      string
+	}{
+		{"abc", "eth0"},
+		{"abc", "net1-storage-a"},
+		{"abc", "net1-storage-b"},
+		{"abd", "net1-storage-a"},
+	}
+
+	names := make(map[string]bool)
+	for _, tt := range tests {
+		name := ifbName(tt.containerID, tt.ifname)
+		if len(name) != MaxInterfaceName || !strings.HasPrefix(name, IfbLinkPrefix) {
+			t.Errorf("ifbName(%q, %q) = %q, expected %v characters starting %v", tt.containerID, tt.ifname, name, MaxInterfaceName, IfbLinkPrefix)
+		}
+		if again := ifbName(tt.containerID, tt.ifname); again != name {
+			t.Errorf("ifbName(%q, %q) changed from %q to %q", tt.containerID, tt.ifname, name, again)
+		}
+		if names[name] {
+			t.Errorf("ifbName(%q, %q) = %q collides with another attachment", tt.containerID, tt.ifname, name)
+		}
+		names[name] = true
+	}
+}
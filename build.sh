@@ -1,3 +1,5 @@
 #!/bin/bash
 
 go build -o bin/vxlan vxlan/main.go
+go build -o bin/vxlan-agent vxlan-agent/main.go
+go build -o bin/vxlan-evpn vxlan-evpn/main.go
@@ -0,0 +1,211 @@
+package vxlan
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+//CheckError represents a mismatch between the expected and actual state of an attachment
+//Code is the CNI error code that should be returned to the runtime
+type CheckError struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+func newCheckError(code int, message string, err error) *CheckError {
+	return &CheckError{
+		Code:    code,
+		Message: message,
+		Err:     err,
+	}
+}
+
+func (e *CheckError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+//GetHostInterface gets the existing host interface without creating or repairing any of its components
+//routing is held while Delete removes the shared rules and routes, and may be nil
+func GetHostInterface(vxlan *Vxlan, routing *RoutingLock) (*HostInterface, error) {
+	hi, err := getHostInterface(vxlan)
+	hi.routing = routing
+	return hi, err
+}
+
+//Check verifies that the host side of the vxlan is intact
+func (hi *HostInterface) Check() error {
+	log.Debugf("HostInterface.Check()")
+	if hi.vxLink == nil {
+		return newCheckError(CheckCodeHostInterface, fmt.Sprintf("%v interface is missing", hi.vxName), nil)
+	}
+
+	if hi.mvLink == nil {
+		return newCheckError(CheckCodeHostInterface, fmt.Sprintf("%v interface is missing", hi.mvName), nil)
+	}
+
+	if !hi.inVRF() {
+		return newCheckError(CheckCodeHostInterface, fmt.Sprintf("%v interface is not enslaved to vrf %v", hi.mvName, hi.VxlanParams.VRF), nil)
+	}
+
+	mtu, err := hi.linkMTU()
+	if err != nil {
+		return newCheckError(CheckCodeMTU, "failed to determine network mtu", err)
+	}
+	hi.mtu = mtu
+
+	for _, l := range []netlink.Link{hi.vxLink, hi.mvLink} {
+		if mtu > 0 && l.Attrs().MTU != mtu {
+			return newCheckError(CheckCodeMTU, fmt.Sprintf("%v interface has mtu %v, expected %v", l.Attrs().Name, l.Attrs().MTU, mtu), nil)
+		}
+	}
+
+	for _, gateway := range hi.GetGateways() {
+		if !hi.hasAddress(gateway) {
+			return newCheckError(CheckCodeHostInterface, fmt.Sprintf("%v interface is missing gateway address %v", hi.mvName, gateway), nil)
+		}
+
+		if !hi.VxlanParams.HasBypassRoute() {
+			continue
+		}
+
+		found, err := hi.hasBypassRoute(gateway)
+		if err != nil {
+			return newCheckError(CheckCodeBypassRoute, "failed to list bypass routes", err)
+		}
+		if !found {
+			return newCheckError(CheckCodeBypassRoute, fmt.Sprintf("bypass route for %v missing from table %v", gateway, hi.VxlanParams.GetRouteTable()), nil)
+		}
+
+		if hi.VxlanParams.VRF != "" {
+			continue
+		}
+
+		found, err = hi.hasRule(gateway)
+		if err != nil {
+			return newCheckError(CheckCodeBypassRule, "failed to list rules", err)
+		}
+		if !found {
+			return newCheckError(CheckCodeBypassRule, fmt.Sprintf("bypass rule for %v to table %v missing", gateway, hi.VxlanParams.GetRouteTable()), nil)
+		}
+	}
+
+	return nil
+}
+
+//CheckContainerLink verifies that the container interface exists in the namespace,
+//carries the expected addresses and routes, see ContainerRoutes, and is a macvlan slaved to this vxlan
+func (hi *HostInterface) CheckContainerLink(namespace, name string, addrs, dsts []*net.IPNet) error {
+	log.WithFields(log.Fields{"namespace": namespace, "name": name, "addrs": addrs}).Debugf("HostInterface.CheckContainerLink()")
+	if hi.vxLink == nil {
+		return newCheckError(CheckCodeHostInterface, fmt.Sprintf("%v interface is missing", hi.vxName), nil)
+	}
+
+	rootns, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer rootns.Close()
+
+	cns, err := netns.GetFromPath(namespace)
+	if err != nil {
+		return newCheckError(CheckCodeContainerLink, "failed to open container namespace", err)
+	}
+	defer cns.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err = netns.Set(cns)
+	if err != nil {
+		return err
+	}
+	defer netns.Set(rootns)
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return newCheckError(CheckCodeContainerLink, fmt.Sprintf("container interface %v not found", name), err)
+	}
+
+	if _, ok := link.(*netlink.Macvlan); !ok {
+		return newCheckError(CheckCodeContainerParent, fmt.Sprintf("container interface %v is a %v, not a macvlan", name, link.Type()), nil)
+	}
+
+	if link.Attrs().ParentIndex != hi.vxLink.Attrs().Index {
+		return newCheckError(CheckCodeContainerParent, fmt.Sprintf("container interface %v is not slaved to %v", name, hi.vxName), nil)
+	}
+
+	//the mtu is only known once Check has run
+	if hi.mtu > 0 && link.Attrs().MTU != hi.mtu {
+		return newCheckError(CheckCodeMTU, fmt.Sprintf("container interface %v has mtu %v, expected %v", name, link.Attrs().MTU, hi.mtu), nil)
+	}
+
+	linkAddrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return newCheckError(CheckCodeContainerAddress, "failed to list container addresses", err)
+	}
+
+	for _, addr := range addrs {
+		if !containsAddr(linkAddrs, addr) {
+			return newCheckError(CheckCodeContainerAddress, fmt.Sprintf("container interface %v is missing address %v", name, addr), nil)
+		}
+	}
+
+	routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return newCheckError(CheckCodeContainerRoute, "failed to list container routes", err)
+	}
+
+	for _, dst := range dsts {
+		gateway := hi.GetGatewayFor(dst.IP)
+		if gateway == nil {
+			continue
+		}
+
+		if !containsRoute(routes, dst, gateway.IP) {
+			return newCheckError(CheckCodeContainerRoute, fmt.Sprintf("container route to %v via %v is missing", dst, gateway.IP), nil)
+		}
+	}
+
+	return nil
+}
+
+func containsAddr(addrs []netlink.Addr, addr *net.IPNet) bool {
+	for _, a := range addrs {
+		if a.IP.Equal(addr.IP) && a.Mask.String() == addr.Mask.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func containsRoute(routes []netlink.Route, dst *net.IPNet, gateway net.IP) bool {
+	for _, r := range routes {
+		if !r.Gw.Equal(gateway) {
+			continue
+		}
+		if isDefaultRoute(dst) && isDefaultRoute(r.Dst) {
+			return true
+		}
+		if r.Dst != nil && r.Dst.String() == dst.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func isDefaultRoute(dst *net.IPNet) bool {
+	if dst == nil {
+		return true
+	}
+	ones, _ := dst.Mask.Size()
+	return ones == 0 && dst.IP.IsUnspecified()
+}
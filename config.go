@@ -2,6 +2,7 @@ package vxlan
 
 import (
 	"encoding/json"
+	"time"
 
 	cni "github.com/phdata/go-libcni"
 )
@@ -13,16 +14,100 @@ type Config struct {
 	K8sNetworkFromNamespace bool     `json:"k8sNetworkFromNamespace"`
 	K8sReadAnnotations      bool     `json:"k8sReadAnnotations"`
 	K8sConfigPath           string   `json:"k8sConfigPath"`
+	K8sNetworkStatus        bool     `json:"k8sNetworkStatus"`
+	NeighborRecordsPath     string   `json:"neighborRecordsPath"`
+	LogFile                 string   `json:"logFile"`
+	LogLevel                string   `json:"logLevel"`
+	LogFormat               string   `json:"logFormat"`
+	LogSink                 string   `json:"logSink"`
+	LogMaxSize              int      `json:"logMaxSize"`
+	LogMaxBackups           int      `json:"logMaxBackups"`
+	LockDir                 string   `json:"lockDir"`
+	Timeout                 int      `json:"timeout"`
+	RouteTable              int      `json:"routeTable"`
+	RulePriority            int      `json:"rulePriority"`
+	BypassRoute             *bool    `json:"bypassRoute"`
+	RequireLocalMAC         bool     `json:"requireLocalMAC"`
+	DNS                     *cni.DNS `json:"dns"`
+	Policy                  *Policy  `json:"policy"`
 	Vxlans                  []*Vxlan `json:"vxlans"`
+
+	//RuntimeConfig is only populated for the capabilities the network list enables
+	RuntimeConfig *RuntimeConfig `json:"runtimeConfig"`
+
+	//ValidAttachments is only populated for GC, it lists every attachment the runtime still considers in use
+	ValidAttachments []*Attachment `json:"cni.dev/valid-attachments,omitempty"`
+
+	raw []byte
+}
+
+//RuntimeConfig holds the capability args passed by the runtime for the capabilities the network list enables
+type RuntimeConfig struct {
+	Bandwidth *Bandwidth `json:"bandwidth"`
+	MAC       string     `json:"mac"`
+}
+
+// Attachment identifies a container interface attached by this plugin
+type Attachment struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
 }
 
 // NewConfig returns a new vxlan config from the byte array
 func NewConfig(confBytes []byte) (*Config, error) {
-	conf := &Config{}
+	conf := &Config{raw: confBytes}
 	err := json.Unmarshal(confBytes, conf)
 	if err != nil {
 		return nil, err
 	}
 
+	//routing settings at the top level apply to every network that doesn't set its own
+	for _, v := range conf.Vxlans {
+		if v == nil {
+			continue
+		}
+		if v.RouteTable == 0 {
+			v.RouteTable = conf.RouteTable
+		}
+		if v.RulePriority == 0 {
+			v.RulePriority = conf.RulePriority
+		}
+		if v.BypassRoute == nil {
+			v.BypassRoute = conf.BypassRoute
+		}
+		if conf.Policy != nil {
+			v.policy = conf.policyFor(v)
+		}
+		v.lockDir = conf.LockDir
+	}
+
 	return conf, nil
 }
+
+//Bytes returns the raw config the Config was parsed from, for passing on to delegated plugins
+func (c *Config) Bytes() []byte {
+	return c.raw
+}
+
+//GetLockDir returns the directory holding locks, reference counts and attachment state
+func (c *Config) GetLockDir() string {
+	if c.LockDir != "" {
+		return c.LockDir
+	}
+	return DefaultLockPath
+}
+
+//LockWait returns how long an invocation may wait for its network's lock
+//it is the runtime's timeout, less the time the IPAM plugin may take to allocate and release an address
+func (c *Config) LockWait() time.Duration {
+	timeout := DefaultTimeout
+	if c.Timeout > 0 {
+		timeout = c.Timeout
+	}
+
+	wait := timeout - 2*DefaultIPAMTimeout
+	if wait < timeout/2 {
+		wait = timeout / 2
+	}
+	return time.Duration(wait) * time.Second
+}
@@ -1,21 +1,130 @@
 package vxlan
 
+import "time"
+
 const (
 	//DefaultIPAMTimeout is how long to wait for the IPAM plugin
 	DefaultIPAMTimeout = 10
 
+	//DefaultLogLevel is the log level used when logLevel is not configured
+	DefaultLogLevel = "info"
+
+	//DefaultLogIdentifier is the identifier used for syslog and journald entries
+	DefaultLogIdentifier = "vxlan-cni"
+
+	//DefaultJournaldSocket is the socket journald receives native protocol entries on
+	DefaultJournaldSocket = "/run/systemd/journal/socket"
+
 	//DefaultLockPath is the default path to store vxlan locks
 	DefaultLockPath = "/tmp"
 
 	//DefaultLockExt is the default extension of the lock file
 	DefaultLockExt = ".lock"
 
+	//DefaultLockRetry is how often a waiter retries a held lock
+	DefaultLockRetry = 50 * time.Millisecond
+
+	//DefaultTimeout is the number of seconds the runtime is assumed to allow an invocation when timeout is not configured
+	DefaultTimeout = 60
+
+	//DefaultRefCountExt is the default extension of the file tracking containers attached to a vxlan
+	DefaultRefCountExt = ".refs"
+
+	//DefaultRemotesExt is the extension of the file recording the flood entries added for a vxlan's remotes
+	DefaultRemotesExt = ".remotes"
+
+	//DefaultRecordExt is the extension of node records in the shared neighbor records directory
+	DefaultRecordExt = ".json"
+
+	//DefaultStateDir is the directory under the lock path holding the records of local attachments
+	DefaultStateDir = "vxlan-state"
+
+	//VxlanLinkPrefix is the name prefix of the host's vxlan interfaces
+	VxlanLinkPrefix = "vx_"
+
+	//TempLinkPrefix is the name prefix of container links before they are renamed in the container's namespace
+	TempLinkPrefix = "cmvl_"
+
+	//IfbLinkPrefix is the name prefix of the ifb devices shaping container interfaces' ingress
+	IfbLinkPrefix = "ifb_"
+
+	//DefaultPolicyTable is the nftables table, in the inet family, enforcing the inter network policy
+	DefaultPolicyTable = "vxlan_cni"
+
+	//DefaultNftBin is the nft binary used to install the inter network policy
+	DefaultNftBin = "nft"
+
+	//DefaultBurstLatency is how many microseconds of traffic at the limited rate make up the default burst
+	DefaultBurstLatency = 10000
+
+	//DefaultTBFLatency is how many microseconds of traffic a token bucket filter queues beyond its burst
+	DefaultTBFLatency = 25000
+
+	//MinBurst is the smallest default burst in bits, enough for a few full size frames
+	MinBurst = 128 * 1024
+
 	//DefaultVxlanRouteTable is the route table number used to store routes that override the /32 routes
 	DefaultVxlanRouteTable = 192
 
+	//MaxVNI is the largest vxlan network identifier, VNIs are 24 bits
+	MaxVNI = 1<<24 - 1
+
+	//VxlanOverheadV4 is the outer ethernet, ipv4, udp and vxlan headers added to every frame on an ipv4 underlay
+	VxlanOverheadV4 = 50
+
+	//VxlanOverheadV6 is the same overhead on an ipv6 underlay
+	VxlanOverheadV6 = 70
+
+	//MinMTU is the smallest mtu ipv4 allows, MinMTUV6 the smallest ipv6 allows
+	MinMTU   = 68
+	MinMTUV6 = 1280
+
+	//MaxMTU is the largest mtu a link can have
+	MaxMTU = 65535
+
+	//MaxInterfaceName is the longest interface name the kernel allows (IFNAMSIZ less the trailing NUL)
+	MaxInterfaceName = 15
+
 	//NetworkAnnotation is the string key where we search for the name of the vxlan to join
 	NetworkAnnotation = "vxlan-cni.phdata.io/NetworkName"
 
+	//MACAnnotation is the string key where we search for the mac address requested for the first network's interface
+	MACAnnotation = "vxlan-cni.phdata.io/RequestedMAC"
+
+	//MultusNetworksAnnotation is the network selection annotation of the kubernetes network plumbing working group, as used by Multus
+	//it is read if the NetworkAnnotation isn't set
+	MultusNetworksAnnotation = "k8s.v1.cni.cncf.io/networks"
+
+	//MultusNetworkStatusAnnotation is where the status of a pod's interfaces is written back, if enabled
+	MultusNetworkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+
 	//AddressAnnotation is the string key where we search for the IP address requested
 	AddressAnnotation = "vxlan-cni.phdata.io/RequestedAddress"
+
+	//FirewallAnnotation is the string key where we search for the container's firewall, as json
+	FirewallAnnotation = "vxlan-cni.phdata.io/Firewall"
+
+	//CheckCodeContainerLink is returned by CHECK when the container interface is missing
+	CheckCodeContainerLink = 100
+
+	//CheckCodeContainerAddress is returned by CHECK when the container interface doesn't carry the expected address
+	CheckCodeContainerAddress = 101
+
+	//CheckCodeContainerRoute is returned by CHECK when a container route is missing
+	CheckCodeContainerRoute = 102
+
+	//CheckCodeContainerParent is returned by CHECK when the container interface is not a macvlan on the expected vxlan
+	CheckCodeContainerParent = 103
+
+	//CheckCodeHostInterface is returned by CHECK when the host vxlan or macvlan interface is missing or unaddressed
+	CheckCodeHostInterface = 104
+
+	//CheckCodeBypassRoute is returned by CHECK when the bypass route is missing
+	CheckCodeBypassRoute = 105
+
+	//CheckCodeBypassRule is returned by CHECK when the bypass rule is missing
+	CheckCodeBypassRule = 106
+
+	//CheckCodeMTU is returned by CHECK when a host or container interface doesn't have the network's mtu
+	CheckCodeMTU = 107
 )
@@ -0,0 +1,421 @@
+package evpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+
+	headerLen = 19
+	maxMsgLen = 4096
+
+	//unreachOverhead is the size of an update withdrawing routes, without the routes:
+	//the header, withdrawn routes and path attribute lengths, the mp_unreach attribute header and its afi and safi
+	unreachOverhead = headerLen + 4 + 4 + 3
+
+	afiL2VPN  = 25
+	safiEVPN  = 70
+	asTrans   = 23456
+	bgpPort   = "179"
+	bgpVer    = 4
+	capMP     = 1
+	capAS4    = 65
+	optParCap = 2
+
+	attrOrigin         = 1
+	attrASPath         = 2
+	attrLocalPref      = 5
+	attrMPReach        = 14
+	attrMPUnreach      = 15
+	attrExtCommunities = 16
+	attrPMSITunnel     = 22
+
+	flagOptional   = 0x80
+	flagTransitive = 0x40
+	flagExtLen     = 0x10
+
+	asSequence = 2
+
+	//tunnelIngressReplication is the PMSI tunnel type for head-end replication
+	tunnelIngressReplication = 6
+	//encapVXLAN is the BGP encapsulation extended community tunnel type for VXLAN
+	encapVXLAN = 8
+)
+
+type open struct {
+	ASN      uint32
+	HoldTime uint16
+	RouterID net.IP
+	EVPN     bool
+	AS4      bool
+}
+
+//update is an EVPN update, all routes are carried in the multiprotocol attributes
+type update struct {
+	NextHop  net.IP
+	Reach    []*Route
+	Unreach  []*Route
+	ASPath   []uint32
+	iBGP     bool
+	localASN uint32
+	as4      bool
+}
+
+func writeMessage(w io.Writer, typ byte, body []byte) error {
+	if headerLen+len(body) > maxMsgLen {
+		return fmt.Errorf("bgp message of %v bytes exceeds the %v byte maximum", headerLen+len(body), maxMsgLen)
+	}
+
+	msg := make([]byte, headerLen, headerLen+len(body))
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:], uint16(headerLen+len(body)))
+	msg[18] = typ
+	msg = append(msg, body...)
+
+	_, err := w.Write(msg)
+	return err
+}
+
+//withdrawals packs the routes into as few updates as fit in maxMsgLen
+func withdrawals(routes []*Route) []*update {
+	var us []*update
+	var batch []*Route
+	size := unreachOverhead
+	for _, r := range routes {
+		l := len(r.marshal())
+		if len(batch) > 0 && size+l > maxMsgLen {
+			us = append(us, &update{Unreach: batch})
+			batch, size = nil, unreachOverhead
+		}
+		batch = append(batch, r)
+		size += l
+	}
+	if len(batch) > 0 {
+		us = append(us, &update{Unreach: batch})
+	}
+	return us
+}
+
+func readMessage(r io.Reader) (byte, []byte, error) {
+	hdr := make([]byte, headerLen)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	l := int(binary.BigEndian.Uint16(hdr[16:]))
+	if l < headerLen || l > maxMsgLen {
+		return 0, nil, fmt.Errorf("invalid bgp message length %v", l)
+	}
+
+	body := make([]byte, l-headerLen)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return hdr[18], body, nil
+}
+
+func (o *open) marshal() []byte {
+	var caps bytes.Buffer
+	caps.Write([]byte{capMP, 4})
+	binary.Write(&caps, binary.BigEndian, uint16(afiL2VPN))
+	caps.Write([]byte{0, safiEVPN})
+	caps.Write([]byte{capAS4, 4})
+	binary.Write(&caps, binary.BigEndian, o.ASN)
+
+	var b bytes.Buffer
+	b.WriteByte(bgpVer)
+	as := uint16(asTrans)
+	if o.ASN <= 0xffff {
+		as = uint16(o.ASN)
+	}
+	binary.Write(&b, binary.BigEndian, as)
+	binary.Write(&b, binary.BigEndian, o.HoldTime)
+	b.Write(o.RouterID.To4())
+	b.WriteByte(byte(caps.Len() + 2))
+	b.Write([]byte{optParCap, byte(caps.Len())})
+	b.Write(caps.Bytes())
+	return b.Bytes()
+}
+
+func parseOpen(body []byte) (*open, error) {
+	if len(body) < 10 {
+		return nil, fmt.Errorf("short open message")
+	}
+	if body[0] != bgpVer {
+		return nil, fmt.Errorf("unsupported bgp version %v", body[0])
+	}
+
+	o := &open{
+		ASN:      uint32(binary.BigEndian.Uint16(body[1:])),
+		HoldTime: binary.BigEndian.Uint16(body[3:]),
+		RouterID: net.IP(body[5:9]),
+	}
+
+	params := body[10:]
+	if len(params) < int(body[9]) {
+		return nil, fmt.Errorf("short open optional parameters")
+	}
+	params = params[:body[9]]
+
+	for len(params) >= 2 {
+		typ, l := params[0], int(params[1])
+		if len(params) < 2+l {
+			return nil, fmt.Errorf("short open optional parameter")
+		}
+		val := params[2 : 2+l]
+		params = params[2+l:]
+		if typ != optParCap {
+			continue
+		}
+
+		for len(val) >= 2 {
+			code, cl := val[0], int(val[1])
+			if len(val) < 2+cl {
+				return nil, fmt.Errorf("short capability")
+			}
+			cv := val[2 : 2+cl]
+			val = val[2+cl:]
+
+			switch {
+			case code == capMP && cl == 4:
+				if binary.BigEndian.Uint16(cv) == afiL2VPN && cv[3] == safiEVPN {
+					o.EVPN = true
+				}
+			case code == capAS4 && cl == 4:
+				o.AS4 = true
+				o.ASN = binary.BigEndian.Uint32(cv)
+			}
+		}
+	}
+
+	return o, nil
+}
+
+func notification(code, subcode byte) []byte {
+	return []byte{code, subcode}
+}
+
+func writeAttr(b *bytes.Buffer, flags, typ byte, val []byte) {
+	if len(val) > 0xff {
+		flags |= flagExtLen
+	}
+	b.Write([]byte{flags, typ})
+	if flags&flagExtLen != 0 {
+		binary.Write(b, binary.BigEndian, uint16(len(val)))
+	} else {
+		b.WriteByte(byte(len(val)))
+	}
+	b.Write(val)
+}
+
+//marshal encodes the update, routes in Reach must share the same attributes (next hop and vni)
+func (u *update) marshal() []byte {
+	var attrs bytes.Buffer
+
+	if len(u.Reach) > 0 {
+		writeAttr(&attrs, flagTransitive, attrOrigin, []byte{0})
+
+		var path bytes.Buffer
+		if !u.iBGP {
+			path.Write([]byte{asSequence, 1})
+			if u.as4 {
+				binary.Write(&path, binary.BigEndian, u.localASN)
+			} else {
+				binary.Write(&path, binary.BigEndian, uint16(u.localASN))
+			}
+		}
+		writeAttr(&attrs, flagTransitive, attrASPath, path.Bytes())
+
+		if u.iBGP {
+			lp := make([]byte, 4)
+			binary.BigEndian.PutUint32(lp, 100)
+			writeAttr(&attrs, flagTransitive, attrLocalPref, lp)
+		}
+
+		var mp bytes.Buffer
+		binary.Write(&mp, binary.BigEndian, uint16(afiL2VPN))
+		mp.WriteByte(safiEVPN)
+		nh := ipBytes(u.NextHop)
+		mp.WriteByte(byte(len(nh)))
+		mp.Write(nh)
+		mp.WriteByte(0)
+		for _, r := range u.Reach {
+			mp.Write(r.marshal())
+		}
+		writeAttr(&attrs, flagOptional|flagExtLen, attrMPReach, mp.Bytes())
+
+		r := u.Reach[0]
+		var ec bytes.Buffer
+		ec.Write(routeTarget(u.localASN, r.VNI))
+		ec.Write([]byte{0x03, 0x0c, 0, 0, 0, 0, 0, encapVXLAN})
+		writeAttr(&attrs, flagOptional|flagTransitive, attrExtCommunities, ec.Bytes())
+
+		if r.Type == RouteTypeInclusiveMulticast {
+			var pmsi bytes.Buffer
+			pmsi.Write([]byte{0, tunnelIngressReplication})
+			pmsi.Write(label(r.VNI))
+			pmsi.Write(ipBytes(r.OriginIP))
+			writeAttr(&attrs, flagOptional|flagTransitive, attrPMSITunnel, pmsi.Bytes())
+		}
+	}
+
+	if len(u.Unreach) > 0 {
+		var mp bytes.Buffer
+		binary.Write(&mp, binary.BigEndian, uint16(afiL2VPN))
+		mp.WriteByte(safiEVPN)
+		for _, r := range u.Unreach {
+			mp.Write(r.marshal())
+		}
+		writeAttr(&attrs, flagOptional|flagExtLen, attrMPUnreach, mp.Bytes())
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint16(0))
+	binary.Write(&b, binary.BigEndian, uint16(attrs.Len()))
+	b.Write(attrs.Bytes())
+	return b.Bytes()
+}
+
+func parseUpdate(body []byte, as4 bool) (*update, error) {
+	if len(body) < 4 {
+		return nil, fmt.Errorf("short update message")
+	}
+
+	wl := int(binary.BigEndian.Uint16(body))
+	if len(body) < 4+wl {
+		return nil, fmt.Errorf("short update withdrawn routes")
+	}
+	al := int(binary.BigEndian.Uint16(body[2+wl:]))
+	attrs := body[4+wl:]
+	if len(attrs) < al {
+		return nil, fmt.Errorf("short update path attributes")
+	}
+	attrs = attrs[:al]
+
+	u := &update{}
+	var pmsiVNI uint32
+	for len(attrs) >= 3 {
+		flags, typ := attrs[0], attrs[1]
+		var l, off int
+		if flags&flagExtLen != 0 {
+			if len(attrs) < 4 {
+				return nil, fmt.Errorf("short path attribute")
+			}
+			l, off = int(binary.BigEndian.Uint16(attrs[2:])), 4
+		} else {
+			l, off = int(attrs[2]), 3
+		}
+		if len(attrs) < off+l {
+			return nil, fmt.Errorf("short path attribute")
+		}
+		val := attrs[off : off+l]
+		attrs = attrs[off+l:]
+
+		switch typ {
+		case attrASPath:
+			u.ASPath = parseASPath(val, as4)
+		case attrMPReach:
+			if len(val) < 5 || binary.BigEndian.Uint16(val) != afiL2VPN || val[2] != safiEVPN {
+				continue
+			}
+			nhl := int(val[3])
+			if len(val) < 5+nhl {
+				return nil, fmt.Errorf("short mp_reach_nlri")
+			}
+			u.NextHop = net.IP(val[4 : 4+nhl])
+			//the next hop may carry both a global and link local ipv6 address, only the first is used
+			if nhl == 32 {
+				u.NextHop = u.NextHop[:16]
+			}
+			routes, err := parseRoutes(val[5+nhl:])
+			if err != nil {
+				return nil, err
+			}
+			u.Reach = routes
+		case attrPMSITunnel:
+			if len(val) >= 5 {
+				pmsiVNI = uint32(val[2])<<16 | uint32(val[3])<<8 | uint32(val[4])
+			}
+		case attrMPUnreach:
+			if len(val) < 3 || binary.BigEndian.Uint16(val) != afiL2VPN || val[2] != safiEVPN {
+				continue
+			}
+			routes, err := parseRoutes(val[3:])
+			if err != nil {
+				return nil, err
+			}
+			u.Unreach = routes
+		}
+	}
+
+	//inclusive multicast routes carry their vni in the pmsi tunnel attribute rather than the nlri
+	for _, r := range u.Reach {
+		if r.Type == RouteTypeInclusiveMulticast {
+			r.VNI = pmsiVNI
+		}
+	}
+
+	return u, nil
+}
+
+func parseASPath(val []byte, as4 bool) []uint32 {
+	size := 2
+	if as4 {
+		size = 4
+	}
+
+	var path []uint32
+	for len(val) >= 2 {
+		n := int(val[1])
+		val = val[2:]
+		for i := 0; i < n && len(val) >= size; i++ {
+			if as4 {
+				path = append(path, binary.BigEndian.Uint32(val))
+			} else {
+				path = append(path, uint32(binary.BigEndian.Uint16(val)))
+			}
+			val = val[size:]
+		}
+	}
+	return path
+}
+
+//routeTarget returns the auto derived ASN:VNI route target extended community
+func routeTarget(asn, vni uint32) []byte {
+	b := make([]byte, 8)
+	if asn <= 0xffff {
+		b[0], b[1] = 0x00, 0x02
+		binary.BigEndian.PutUint16(b[2:], uint16(asn))
+		binary.BigEndian.PutUint32(b[4:], vni)
+		return b
+	}
+	b[0], b[1] = 0x02, 0x02
+	binary.BigEndian.PutUint32(b[2:], asn)
+	binary.BigEndian.PutUint16(b[6:], uint16(vni))
+	return b
+}
+
+//label encodes the vni into the 24 bit mpls label field as described in RFC 8365
+func label(vni uint32) []byte {
+	return []byte{byte(vni >> 16), byte(vni >> 8), byte(vni)}
+}
+
+func ipBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
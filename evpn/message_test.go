@@ -0,0 +1,316 @@
+package evpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	err := writeMessage(&b, msgUpdate, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if b.Len() != headerLen+3 {
+		t.Fatalf("expected %v bytes, got %v", headerLen+3, b.Len())
+	}
+
+	typ, body, err := readMessage(&b)
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	if typ != msgUpdate || !bytes.Equal(body, []byte{1, 2, 3}) {
+		t.Errorf("expected update with body 010203, got type %v body %x", typ, body)
+	}
+}
+
+func TestWriteMessageTooLong(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeMessage(&b, msgUpdate, make([]byte, maxMsgLen-headerLen)); err != nil {
+		t.Errorf("expected a message of the maximum length to be written, got %v", err)
+	}
+	b.Reset()
+	if err := writeMessage(&b, msgUpdate, make([]byte, maxMsgLen-headerLen+1)); err == nil {
+		t.Errorf("expected an error for a message over the maximum length")
+	}
+	if b.Len() != 0 {
+		t.Errorf("expected nothing written for a message over the maximum length, got %v bytes", b.Len())
+	}
+}
+
+func TestWithdrawals(t *testing.T) {
+	var routes []*Route
+	for i := 0; i < 500; i++ {
+		mac := net.HardwareAddr{2, 0, 0, 0, byte(i >> 8), byte(i)}
+		routes = append(routes, NewMACIPRoute(65000, 100, mac, net.IP{10, 1, byte(i >> 8), byte(i)}))
+	}
+
+	for _, n := range []int{0, 1, 500} {
+		us := withdrawals(routes[:n])
+
+		var got []string
+		for _, u := range us {
+			body := u.marshal()
+			if headerLen+len(body) > maxMsgLen {
+				t.Fatalf("withdrawing %v routes: update of %v bytes exceeds the maximum", n, headerLen+len(body))
+			}
+			parsed, err := parseUpdate(body, true)
+			if err != nil {
+				t.Fatalf("withdrawing %v routes: failed to parse update: %v", n, err)
+			}
+			for _, r := range parsed.Unreach {
+				got = append(got, r.Key())
+			}
+		}
+
+		if n == 500 && len(us) < 2 {
+			t.Errorf("expected 500 withdrawals to be split, got %v update", len(us))
+		}
+		if len(got) != n {
+			t.Fatalf("expected %v routes withdrawn, got %v", n, len(got))
+		}
+		for i, k := range got {
+			if k != routes[i].Key() {
+				t.Errorf("expected withdrawal %v to be %v, got %v", i, routes[i].Key(), k)
+			}
+		}
+	}
+}
+
+func TestReadMessageMalformed(t *testing.T) {
+	header := func(l uint16) []byte {
+		h := bytes.Repeat([]byte{0xff}, headerLen)
+		binary.BigEndian.PutUint16(h[16:], l)
+		h[18] = msgKeepalive
+		return h
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"short header", header(headerLen)[:10]},
+		{"length below header", header(headerLen - 1)},
+		{"length above maximum", header(maxMsgLen + 1)},
+		{"truncated body", append(header(headerLen+4), 1, 2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readMessage(bytes.NewReader(tt.b))
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestOpenRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		asn  uint32
+	}{
+		{"2 byte asn", 65000},
+		{"4 byte asn", 4200000000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &open{ASN: tt.asn, HoldTime: 90, RouterID: net.ParseIP("10.0.0.1")}
+			po, err := parseOpen(o.marshal())
+			if err != nil {
+				t.Fatalf("failed to parse open: %v", err)
+			}
+			if po.ASN != tt.asn || po.HoldTime != 90 || !po.RouterID.Equal(o.RouterID) || !po.EVPN || !po.AS4 {
+				t.Errorf("expected asn %v, hold time 90, router id %v with evpn and as4, got %+v", tt.asn, o.RouterID, po)
+			}
+		})
+	}
+}
+
+func TestParseOpenMalformed(t *testing.T) {
+	valid := (&open{ASN: 65000, HoldTime: 90, RouterID: net.ParseIP("10.0.0.1")}).marshal()
+	badVersion := append([]byte{3}, valid[1:]...)
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"short", valid[:9]},
+		{"bad version", badVersion},
+		{"truncated parameters", valid[:len(valid)-1]},
+		{"parameter past end", []byte{bgpVer, 0, 1, 0, 90, 10, 0, 0, 1, 2, optParCap, 4}},
+		{"capability past end", []byte{bgpVer, 0, 1, 0, 90, 10, 0, 0, 1, 4, optParCap, 2, capAS4, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseOpen(tt.b)
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseOpenWithoutEVPN(t *testing.T) {
+	//an ipv4 unicast multiprotocol capability only
+	b := []byte{bgpVer, 0xfd, 0xe8, 0, 90, 10, 0, 0, 1, 8, optParCap, 6, capMP, 4, 0, 1, 0, 1}
+	o, err := parseOpen(b)
+	if err != nil {
+		t.Fatalf("failed to parse open: %v", err)
+	}
+	if o.EVPN || o.AS4 || o.ASN != 65000 {
+		t.Errorf("expected asn 65000 without evpn or as4, got %+v", o)
+	}
+}
+
+func TestUpdateRoundTrip(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	macip := NewMACIPRoute(65000, 100, mac, net.ParseIP("10.1.0.5"))
+	imet := NewInclusiveMulticastRoute(65000, 200, net.ParseIP("192.168.0.1"))
+
+	tests := []struct {
+		name   string
+		u      *update
+		as4    bool
+		asPath []uint32
+	}{
+		{"ebgp mac/ip", &update{NextHop: net.ParseIP("192.168.0.1"), Reach: []*Route{macip}, localASN: 65000}, false, []uint32{65000}},
+		{"ebgp as4 inclusive multicast", &update{NextHop: net.ParseIP("192.168.0.1"), Reach: []*Route{imet}, localASN: 4200000000, as4: true}, true, []uint32{4200000000}},
+		{"ibgp ipv6 next hop", &update{NextHop: net.ParseIP("fd01::1"), Reach: []*Route{macip}, localASN: 65000, iBGP: true}, false, nil},
+		{"withdrawal", &update{Unreach: []*Route{macip, imet}}, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := parseUpdate(tt.u.marshal(), tt.as4)
+			if err != nil {
+				t.Fatalf("failed to parse update: %v", err)
+			}
+
+			if !reflect.DeepEqual(u.ASPath, tt.asPath) {
+				t.Errorf("expected as path %v, got %v", tt.asPath, u.ASPath)
+			}
+			if len(tt.u.Reach) > 0 && !u.NextHop.Equal(tt.u.NextHop) {
+				t.Errorf("expected next hop %v, got %v", tt.u.NextHop, u.NextHop)
+			}
+			checkRoutes(t, "reach", tt.u.Reach, u.Reach)
+			checkRoutes(t, "unreach", tt.u.Unreach, u.Unreach)
+		})
+	}
+}
+
+func checkRoutes(t *testing.T, kind string, want, got []*Route) {
+	t.Helper()
+	if len(want) != len(got) {
+		t.Fatalf("expected %v %v routes, got %v", len(want), kind, len(got))
+	}
+	for i := range want {
+		if want[i].Key() != got[i].Key() {
+			t.Errorf("expected %v route %v, got %v", kind, want[i].Key(), got[i].Key())
+		}
+	}
+}
+
+func TestParseUpdatePMSIVNI(t *testing.T) {
+	imet := NewInclusiveMulticastRoute(65000, 0x123456, net.ParseIP("192.168.0.1"))
+	u := &update{NextHop: net.ParseIP("192.168.0.1"), Reach: []*Route{imet}, localASN: 65000}
+
+	pu, err := parseUpdate(u.marshal(), false)
+	if err != nil {
+		t.Fatalf("failed to parse update: %v", err)
+	}
+	if pu.Reach[0].VNI != 0x123456 {
+		t.Errorf("expected the vni from the pmsi tunnel attribute, got %v", pu.Reach[0].VNI)
+	}
+}
+
+func TestParseUpdateTruncated(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	u := &update{
+		NextHop:  net.ParseIP("192.168.0.1"),
+		Reach:    []*Route{NewMACIPRoute(65000, 100, mac, net.ParseIP("10.1.0.5"))},
+		localASN: 65000,
+	}
+	b := u.marshal()
+
+	for i := 0; i < len(b); i++ {
+		_, err := parseUpdate(b[:i], false)
+		if err == nil {
+			t.Errorf("expected an error parsing the update truncated to %v of %v bytes", i, len(b))
+		}
+	}
+}
+
+func TestParseUpdateMalformedAttribute(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs []byte
+	}{
+		{"attribute past end", []byte{flagTransitive, attrOrigin, 4, 0}},
+		{"extended length past end", []byte{flagOptional | flagExtLen, attrMPReach, 1}},
+		{"next hop past end", []byte{flagOptional, attrMPReach, 5, 0, afiL2VPN, safiEVPN, 16, 0}},
+		{"reach nlri past end", []byte{flagOptional, attrMPReach, 11, 0, afiL2VPN, safiEVPN, 4, 10, 0, 0, 1, 0, RouteTypeMACIP, 40}},
+		{"unreach nlri past end", []byte{flagOptional, attrMPUnreach, 5, 0, afiL2VPN, safiEVPN, RouteTypeInclusiveMulticast, 17}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, 4, 4+len(tt.attrs))
+			binary.BigEndian.PutUint16(b[2:], uint16(len(tt.attrs)))
+			_, err := parseUpdate(append(b, tt.attrs...), false)
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseASPath(t *testing.T) {
+	tests := []struct {
+		name string
+		val  []byte
+		as4  bool
+		want []uint32
+	}{
+		{"2 byte", []byte{asSequence, 2, 0xfd, 0xe8, 0xfd, 0xe9}, false, []uint32{65000, 65001}},
+		{"4 byte", []byte{asSequence, 1, 0xfa, 0x56, 0xea, 0x00}, true, []uint32{4200000000}},
+		{"several segments", []byte{asSequence, 1, 0, 1, asSequence, 1, 0, 2}, false, []uint32{1, 2}},
+		{"truncated", []byte{asSequence, 2, 0, 0, 0, 1, 0}, true, []uint32{1}},
+		{"empty", nil, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseASPath(tt.val, tt.as4)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRouteTarget(t *testing.T) {
+	tests := []struct {
+		name string
+		asn  uint32
+		vni  uint32
+		want []byte
+	}{
+		{"2 byte asn", 65000, 100, []byte{0x00, 0x02, 0xfd, 0xe8, 0, 0, 0, 100}},
+		{"4 byte asn", 4200000000, 100, []byte{0x02, 0x02, 0xfa, 0x56, 0xea, 0x00, 0, 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := routeTarget(tt.asn, tt.vni)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("expected %x, got %x", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package evpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	//RouteTypeMACIP is the EVPN MAC/IP advertisement route, advertising a container's mac and address
+	RouteTypeMACIP = 2
+
+	//RouteTypeInclusiveMulticast is the EVPN inclusive multicast ethernet tag route, advertising a vtep's membership in a vni
+	RouteTypeInclusiveMulticast = 3
+)
+
+//Route is an EVPN route of type 2 or 3
+type Route struct {
+	Type     byte
+	RD       [8]byte
+	VNI      uint32
+	MAC      net.HardwareAddr
+	IP       net.IP
+	OriginIP net.IP
+	NextHop  net.IP
+}
+
+//NewMACIPRoute returns a type 2 route for the mac and optional ip in the vni
+func NewMACIPRoute(asn, vni uint32, mac net.HardwareAddr, ip net.IP) *Route {
+	return &Route{
+		Type: RouteTypeMACIP,
+		RD:   routeDistinguisher(asn, vni),
+		VNI:  vni,
+		MAC:  mac,
+		IP:   ip,
+	}
+}
+
+//NewInclusiveMulticastRoute returns a type 3 route advertising the vtep as a member of the vni
+func NewInclusiveMulticastRoute(asn, vni uint32, vtep net.IP) *Route {
+	return &Route{
+		Type:     RouteTypeInclusiveMulticast,
+		RD:       routeDistinguisher(asn, vni),
+		VNI:      vni,
+		OriginIP: vtep,
+	}
+}
+
+//routeDistinguisher returns a type 0 RD of asn:vni, the whole 24 bit vni fits its assigned number, so every vni has its own
+//a 4 byte asn doesn't fit the administrator field, which then holds AS_TRANS
+//nodes share the RD of a vni, their routes are told apart by the originating vtep or the mac and ip
+func routeDistinguisher(asn, vni uint32) [8]byte {
+	var rd [8]byte
+	admin := uint16(asTrans)
+	if asn <= 0xffff {
+		admin = uint16(asn)
+	}
+	binary.BigEndian.PutUint16(rd[2:], admin)
+	binary.BigEndian.PutUint32(rd[4:], vni)
+	return rd
+}
+
+//Key uniquely identifies the route's NLRI, it is the same in an advertisement and its withdrawal
+//the vni is left out since withdrawals don't reliably carry it, the RD already distinguishes the vnis
+func (r *Route) Key() string {
+	if r.Type == RouteTypeMACIP {
+		return fmt.Sprintf("%v/%x/%v/%v", r.Type, r.RD, r.MAC, r.IP)
+	}
+	return fmt.Sprintf("%v/%x/%v", r.Type, r.RD, r.OriginIP)
+}
+
+func (r *Route) String() string {
+	if r.Type == RouteTypeMACIP {
+		return fmt.Sprintf("type 2 vni %v mac %v ip %v via %v", r.VNI, r.MAC, r.IP, r.NextHop)
+	}
+	return fmt.Sprintf("type 3 vni %v vtep %v via %v", r.VNI, r.OriginIP, r.NextHop)
+}
+
+func (r *Route) marshal() []byte {
+	var v bytes.Buffer
+	v.Write(r.RD[:])
+
+	switch r.Type {
+	case RouteTypeMACIP:
+		v.Write(make([]byte, 10)) //esi
+		v.Write(make([]byte, 4))  //ethernet tag, vlan based service
+		v.WriteByte(48)
+		v.Write(r.MAC)
+		ip := ipBytes(r.IP)
+		v.WriteByte(byte(len(ip) * 8))
+		v.Write(ip)
+		v.Write(label(r.VNI))
+	case RouteTypeInclusiveMulticast:
+		v.Write(make([]byte, 4)) //ethernet tag, vlan based service
+		ip := ipBytes(r.OriginIP)
+		v.WriteByte(byte(len(ip) * 8))
+		v.Write(ip)
+	}
+
+	return append([]byte{r.Type, byte(v.Len())}, v.Bytes()...)
+}
+
+//parseRoutes parses EVPN NLRI, routes of types other than 2 and 3 are skipped
+func parseRoutes(b []byte) ([]*Route, error) {
+	var routes []*Route
+	for len(b) >= 2 {
+		typ, l := b[0], int(b[1])
+		if len(b) < 2+l {
+			return nil, fmt.Errorf("short evpn nlri")
+		}
+		val := b[2 : 2+l]
+		b = b[2+l:]
+
+		if typ != RouteTypeMACIP && typ != RouteTypeInclusiveMulticast {
+			continue
+		}
+
+		r, err := parseRoute(typ, val)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+func parseRoute(typ byte, val []byte) (*Route, error) {
+	r := &Route{Type: typ}
+	if len(val) < 8 {
+		return nil, fmt.Errorf("short evpn route")
+	}
+	copy(r.RD[:], val)
+	val = val[8:]
+
+	if typ == RouteTypeMACIP {
+		//esi(10) + ethernet tag(4) + mac length(1) + mac(6) + ip length(1)
+		if len(val) < 22 {
+			return nil, fmt.Errorf("short evpn mac/ip route")
+		}
+		r.MAC = net.HardwareAddr(append([]byte{}, val[15:21]...))
+		ipl := int(val[21]) / 8
+		val = val[22:]
+		if len(val) < ipl+3 {
+			return nil, fmt.Errorf("short evpn mac/ip route")
+		}
+		if ipl > 0 {
+			r.IP = net.IP(append([]byte{}, val[:ipl]...))
+		}
+		r.VNI = uint32(val[ipl])<<16 | uint32(val[ipl+1])<<8 | uint32(val[ipl+2])
+		return r, nil
+	}
+
+	//ethernet tag(4) + ip length(1)
+	if len(val) < 5 {
+		return nil, fmt.Errorf("short evpn inclusive multicast route")
+	}
+	ipl := int(val[4]) / 8
+	if len(val) < 5+ipl {
+		return nil, fmt.Errorf("short evpn inclusive multicast route")
+	}
+	r.OriginIP = net.IP(append([]byte{}, val[5:5+ipl]...))
+	return r, nil
+}
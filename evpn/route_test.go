@@ -0,0 +1,120 @@
+package evpn
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRouteRoundTrip(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+
+	tests := []struct {
+		name  string
+		route *Route
+	}{
+		{"mac/ip v4", NewMACIPRoute(65000, 100, mac, net.ParseIP("10.1.0.5"))},
+		{"mac/ip v6", NewMACIPRoute(65000, 16777215, mac, net.ParseIP("fd00::5"))},
+		{"mac only", NewMACIPRoute(65000, 7, mac, nil)},
+		{"inclusive multicast v4", NewInclusiveMulticastRoute(65000, 100, net.ParseIP("192.168.0.1"))},
+		{"inclusive multicast v6", NewInclusiveMulticastRoute(65000, 100, net.ParseIP("fd01::1"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := parseRoutes(tt.route.marshal())
+			if err != nil {
+				t.Fatalf("failed to parse route: %v", err)
+			}
+			if len(routes) != 1 {
+				t.Fatalf("expected 1 route, got %v", len(routes))
+			}
+
+			r := routes[0]
+			if r.Key() != tt.route.Key() {
+				t.Errorf("expected key %v, got %v", tt.route.Key(), r.Key())
+			}
+			//inclusive multicast routes carry the vni in the pmsi tunnel attribute, not the nlri
+			if r.Type == RouteTypeMACIP && r.VNI != tt.route.VNI {
+				t.Errorf("expected vni %v, got %v", tt.route.VNI, r.VNI)
+			}
+		})
+	}
+}
+
+func TestParseRoutesSkipsOtherTypes(t *testing.T) {
+	r := NewInclusiveMulticastRoute(65000, 1, net.ParseIP("192.168.0.1"))
+	//a type 5 ip prefix route with a short body
+	b := append([]byte{5, 3, 1, 2, 3}, r.marshal()...)
+
+	routes, err := parseRoutes(b)
+	if err != nil {
+		t.Fatalf("failed to parse routes: %v", err)
+	}
+	if len(routes) != 1 || routes[0].Key() != r.Key() {
+		t.Errorf("expected only %v, got %v", r, routes)
+	}
+}
+
+func TestParseRoutesMalformed(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	rd := []byte{0, 0, 0xfd, 0xe8, 0, 0, 0, 1}
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"length past end", truncate(NewMACIPRoute(65000, 1, mac, net.ParseIP("10.1.0.5")).marshal(), 1)},
+		{"short mac/ip", nlri(RouteTypeMACIP, rd, make([]byte, 10))},
+		{"ip length past route", nlri(RouteTypeMACIP, rd, make([]byte, 14), []byte{48}, mac, []byte{128, 0, 0, 0})},
+		{"short rd", nlri(RouteTypeInclusiveMulticast, []byte{0, 1, 0, 0})},
+		{"short inclusive multicast", nlri(RouteTypeInclusiveMulticast, rd, []byte{0, 0, 0, 0})},
+		{"origin ip past route", nlri(RouteTypeInclusiveMulticast, rd, []byte{0, 0, 0, 0, 32})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRoutes(tt.b)
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestRouteDistinguisher(t *testing.T) {
+	tests := []struct {
+		name string
+		asn  uint32
+		vni  uint32
+		want [8]byte
+	}{
+		{"2 byte asn", 65000, 0x010203, [8]byte{0, 0, 0xfd, 0xe8, 0, 0x01, 0x02, 0x03}},
+		{"4 byte asn", 4200000000, 0xffffff, [8]byte{0, 0, 0x5b, 0xa0, 0, 0xff, 0xff, 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rd := routeDistinguisher(tt.asn, tt.vni); rd != tt.want {
+				t.Errorf("expected %x, got %x", tt.want, rd)
+			}
+		})
+	}
+
+	//vnis equal in their low 16 bits must not share an RD
+	if routeDistinguisher(65000, 100) == routeDistinguisher(65000, 65636) {
+		t.Errorf("expected vnis 100 and 65636 to have different route distinguishers")
+	}
+}
+
+func truncate(b []byte, n int) []byte {
+	return b[:len(b)-n]
+}
+
+//nlri returns an evpn nlri of the type, with the parts concatenated as its value
+func nlri(typ byte, parts ...[]byte) []byte {
+	var val []byte
+	for _, p := range parts {
+		val = append(val, p...)
+	}
+	return append([]byte{typ, byte(len(val))}, val...)
+}
@@ -0,0 +1,259 @@
+package evpn
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//DefaultHoldTime is the hold time proposed to peers
+	DefaultHoldTime = 90 * time.Second
+
+	//DefaultConnectRetry is how long to wait before reconnecting to a peer
+	DefaultConnectRetry = 5 * time.Second
+)
+
+//Peer is a BGP neighbor exchanging EVPN routes with the speaker
+type Peer struct {
+	Address string
+	ASN     uint32
+}
+
+//session is an established connection to a peer
+type session struct {
+	peer    *Peer
+	conn    net.Conn
+	as4     bool
+	iBGP    bool
+	hold    time.Duration
+	localAS uint32
+	nextHop net.IP
+	mu      sync.Mutex
+
+	//updates waiting for send, so queueing them never waits on the peer
+	pending [][]byte
+	pmu     sync.Mutex
+	wake    chan struct{}
+}
+
+//dial connects to the peer and completes the OPEN/KEEPALIVE exchange
+func dial(p *Peer, asn uint32, routerID, nextHop net.IP) (*session, error) {
+	addr := p.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, bgpPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, DefaultConnectRetry)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		peer:    p,
+		conn:    conn,
+		iBGP:    p.ASN == asn,
+		localAS: asn,
+		nextHop: nextHop,
+		wake:    make(chan struct{}, 1),
+	}
+
+	err = s.open(routerID)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *session) open(routerID net.IP) error {
+	s.conn.SetDeadline(time.Now().Add(DefaultHoldTime))
+	defer s.conn.SetDeadline(time.Time{})
+
+	o := &open{ASN: s.localAS, HoldTime: uint16(DefaultHoldTime / time.Second), RouterID: routerID}
+	err := writeMessage(s.conn, msgOpen, o.marshal())
+	if err != nil {
+		return err
+	}
+
+	typ, body, err := readMessage(s.conn)
+	if err != nil {
+		return err
+	}
+	if typ == msgNotification {
+		return fmt.Errorf("peer sent notification %v", body)
+	}
+	if typ != msgOpen {
+		return fmt.Errorf("expected open, got message type %v", typ)
+	}
+
+	po, err := parseOpen(body)
+	if err != nil {
+		writeMessage(s.conn, msgNotification, notification(2, 0))
+		return err
+	}
+	if po.ASN != s.peer.ASN {
+		//open message error, bad peer as
+		writeMessage(s.conn, msgNotification, notification(2, 2))
+		return fmt.Errorf("peer asn %v does not match configured %v", po.ASN, s.peer.ASN)
+	}
+	if !po.EVPN {
+		//open message error, unsupported capability
+		writeMessage(s.conn, msgNotification, notification(2, 7))
+		return fmt.Errorf("peer does not support l2vpn evpn")
+	}
+
+	s.as4 = po.AS4
+	s.hold = DefaultHoldTime
+	if ph := time.Duration(po.HoldTime) * time.Second; ph < s.hold {
+		s.hold = ph
+	}
+
+	err = writeMessage(s.conn, msgKeepalive, nil)
+	if err != nil {
+		return err
+	}
+
+	typ, body, err = readMessage(s.conn)
+	if err != nil {
+		return err
+	}
+	if typ != msgKeepalive {
+		return fmt.Errorf("expected keepalive, got message type %v: %v", typ, body)
+	}
+
+	return nil
+}
+
+//write sends a message, a peer that doesn't accept it within the hold time fails the write
+func (s *session) write(typ byte, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hold > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.hold))
+	}
+	return writeMessage(s.conn, typ, body)
+}
+
+//updates returns the withdrawals in as few updates as fit in a message, then one update per advertised route,
+//since the route target and pmsi tunnel attributes depend on the route
+func (s *session) updates(reach, unreach []*Route) [][]byte {
+	var msgs [][]byte
+	for _, u := range withdrawals(unreach) {
+		msgs = append(msgs, u.marshal())
+	}
+
+	for _, r := range reach {
+		u := &update{
+			NextHop:  s.nextHop,
+			Reach:    []*Route{r},
+			iBGP:     s.iBGP,
+			localASN: s.localAS,
+			as4:      s.as4,
+		}
+		msgs = append(msgs, u.marshal())
+	}
+
+	return msgs
+}
+
+//queue adds the routes to the updates send will write, in the order they are queued
+func (s *session) queue(reach, unreach []*Route) {
+	msgs := s.updates(reach, unreach)
+	if len(msgs) == 0 {
+		return
+	}
+
+	s.pmu.Lock()
+	s.pending = append(s.pending, msgs...)
+	s.pmu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+//send writes queued updates until done is closed or a write fails
+func (s *session) send(done <-chan struct{}) error {
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-s.wake:
+		}
+
+		s.pmu.Lock()
+		msgs := s.pending
+		s.pending = nil
+		s.pmu.Unlock()
+
+		for _, m := range msgs {
+			err := s.write(msgUpdate, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//keepalive sends keepalives at a third of the hold time until done is closed
+func (s *session) keepalive(done <-chan struct{}) {
+	if s.hold == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.hold / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := s.write(msgKeepalive, nil)
+			if err != nil {
+				log.WithError(err).WithField("peer", s.peer.Address).Debugf("failed to send keepalive")
+				return
+			}
+		}
+	}
+}
+
+//receive reads updates from the peer, passing them to handle, until the session fails
+func (s *session) receive(handle func(*update)) error {
+	for {
+		if s.hold > 0 {
+			s.conn.SetReadDeadline(time.Now().Add(s.hold))
+		}
+
+		typ, body, err := readMessage(s.conn)
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case msgKeepalive:
+		case msgUpdate:
+			u, err := parseUpdate(body, s.as4)
+			if err != nil {
+				//update message error, malformed attribute list
+				s.write(msgNotification, notification(3, 1))
+				return err
+			}
+			handle(u)
+		case msgNotification:
+			return fmt.Errorf("peer sent notification %v", body)
+		default:
+			return fmt.Errorf("unexpected message type %v", typ)
+		}
+	}
+}
+
+func (s *session) close() {
+	s.write(msgNotification, notification(6, 0))
+	s.conn.Close()
+}
@@ -0,0 +1,121 @@
+package evpn
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+//testPeer accepts one connection on a local listener and completes the OPEN/KEEPALIVE exchange as asn
+func testPeer(t *testing.T, asn uint32, evpn bool) (*Peer, <-chan net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	conns := make(chan net.Conn, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			close(conns)
+			return
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		typ, _, err := readMessage(conn)
+		if err != nil || typ != msgOpen {
+			conn.Close()
+			close(conns)
+			return
+		}
+
+		body := (&open{ASN: asn, HoldTime: 30, RouterID: net.ParseIP("10.0.0.2")}).marshal()
+		if !evpn {
+			//keep the as4 capability, drop the multiprotocol one
+			body = []byte{bgpVer, byte(asn >> 8), byte(asn), 0, 30, 10, 0, 0, 2, 8, optParCap, 6, capAS4, 4, byte(asn >> 24), byte(asn >> 16), byte(asn >> 8), byte(asn)}
+		}
+		writeMessage(conn, msgOpen, body)
+		writeMessage(conn, msgKeepalive, nil)
+		readMessage(conn)
+		conns <- conn
+	}()
+
+	return &Peer{Address: l.Addr().String(), ASN: asn}, conns
+}
+
+func TestDial(t *testing.T) {
+	p, conns := testPeer(t, 65001, true)
+	s, err := dial(p, 65000, net.ParseIP("10.0.0.1"), net.ParseIP("192.168.0.1"))
+	if err != nil {
+		t.Fatalf("failed to establish session: %v", err)
+	}
+	defer s.conn.Close()
+
+	conn := <-conns
+	defer conn.Close()
+
+	if s.iBGP || !s.as4 || s.hold != 30*time.Second {
+		t.Errorf("expected an ebgp as4 session with a 30s hold time, got ibgp %v as4 %v hold %v", s.iBGP, s.as4, s.hold)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.send(stop)
+
+	r := NewInclusiveMulticastRoute(65000, 100, net.ParseIP("192.168.0.1"))
+	s.queue([]*Route{r}, nil)
+	s.queue(nil, []*Route{r})
+
+	typ, body, err := readMessage(conn)
+	if err != nil || typ != msgUpdate {
+		t.Fatalf("expected an update, got type %v: %v", typ, err)
+	}
+	u, err := parseUpdate(body, true)
+	if err != nil {
+		t.Fatalf("failed to parse update: %v", err)
+	}
+	if len(u.Reach) != 1 || u.Reach[0].Key() != r.Key() || u.Reach[0].VNI != 100 || !u.NextHop.Equal(net.ParseIP("192.168.0.1")) {
+		t.Errorf("expected %v via 192.168.0.1, got %v via %v", r, u.Reach, u.NextHop)
+	}
+
+	typ, body, err = readMessage(conn)
+	if err != nil || typ != msgUpdate {
+		t.Fatalf("expected an update, got type %v: %v", typ, err)
+	}
+	u, err = parseUpdate(body, true)
+	if err != nil {
+		t.Fatalf("failed to parse update: %v", err)
+	}
+	if len(u.Unreach) != 1 || u.Unreach[0].Key() != r.Key() {
+		t.Errorf("expected withdrawal of %v, got %v", r, u.Unreach)
+	}
+}
+
+func TestDialRejectsPeer(t *testing.T) {
+	tests := []struct {
+		name string
+		asn  uint32
+		evpn bool
+	}{
+		{"wrong asn", 65002, true},
+		{"no evpn", 65001, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conns := testPeer(t, tt.asn, tt.evpn)
+			p.ASN = 65001
+
+			s, err := dial(p, 65000, net.ParseIP("10.0.0.1"), net.ParseIP("192.168.0.1"))
+			if err == nil {
+				s.conn.Close()
+				t.Errorf("expected an error")
+			}
+			if conn, ok := <-conns; ok {
+				conn.Close()
+			}
+		})
+	}
+}
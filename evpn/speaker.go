@@ -0,0 +1,362 @@
+package evpn
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phdata/vxlan-cni"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var zeroMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+
+//Speaker advertises the node's vnis (type 3) and containers (type 2) as EVPN routes to its peers,
+//and installs the routes learned from them as fdb and neighbor entries on the matching vx_ interfaces
+type Speaker struct {
+	ASN         uint32
+	RouterID    net.IP
+	Vtep        net.IP
+	RecordsPath string
+	Interval    time.Duration
+	Peers       []*Peer
+
+	node     string
+	mu       sync.Mutex
+	local    map[string]*Route
+	sessions map[*session]bool
+}
+
+//NewSpeaker returns a new Speaker
+//containers are read from this node's records in recordsPath, as published by the plugin's neighborRecordsPath
+func NewSpeaker(asn uint32, routerID, vtep net.IP, recordsPath string, interval time.Duration, peers []*Peer) (*Speaker, error) {
+	node, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Speaker{
+		ASN:         asn,
+		RouterID:    routerID,
+		Vtep:        vtep,
+		RecordsPath: recordsPath,
+		Interval:    interval,
+		Peers:       peers,
+		node:        node,
+		local:       make(map[string]*Route),
+		sessions:    make(map[*session]bool),
+	}, nil
+}
+
+//Run maintains a session with every peer, and rescans local routes every Interval, until done is closed
+func (sp *Speaker) Run(done <-chan struct{}) error {
+	sp.refresh()
+
+	for _, p := range sp.Peers {
+		go sp.runPeer(p, done)
+	}
+
+	ticker := time.NewTicker(sp.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			sp.refresh()
+		}
+	}
+}
+
+//refresh advertises new local routes and withdraws those that are gone to every established session
+func (sp *Speaker) refresh() {
+	routes, err := sp.localRoutes()
+	if err != nil {
+		log.WithError(err).Errorf("failed to read local routes")
+		return
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	var reach, unreach []*Route
+	for k, r := range routes {
+		if _, ok := sp.local[k]; !ok {
+			reach = append(reach, r)
+		}
+	}
+	for k, r := range sp.local {
+		if _, ok := routes[k]; !ok {
+			unreach = append(unreach, r)
+		}
+	}
+	sp.local = routes
+
+	if len(reach) == 0 && len(unreach) == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{"advertise": len(reach), "withdraw": len(unreach)}).Debugf("local routes changed")
+	//queued under the lock so every session sees the changes in order, each session's sender writes them
+	for s := range sp.sessions {
+		s.queue(reach, unreach)
+	}
+}
+
+//localRoutes returns a type 3 route for every local vx_ interface, and a type 2 route for every container on them
+func (sp *Speaker) localRoutes() (map[string]*Route, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make(map[string]*Route)
+	vnis := make(map[string]uint32)
+	for _, l := range links {
+		vxl, ok := l.(*netlink.Vxlan)
+		if !ok || !strings.HasPrefix(vxl.Name, vxlan.VxlanLinkPrefix) {
+			continue
+		}
+
+		vni := uint32(vxl.VxlanId)
+		vnis[strings.TrimPrefix(vxl.Name, vxlan.VxlanLinkPrefix)] = vni
+		r := NewInclusiveMulticastRoute(sp.ASN, vni, sp.Vtep)
+		routes[r.Key()] = r
+	}
+
+	if sp.RecordsPath == "" {
+		return routes, nil
+	}
+
+	records, err := vxlan.LoadNodeRecords(sp.RecordsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rec := range records {
+		vni, ok := vnis[rec.Network]
+		if rec.Node != sp.node || !ok {
+			continue
+		}
+
+		for _, e := range rec.Entries {
+			mac, err := net.ParseMAC(e.MAC)
+			if err != nil {
+				log.WithError(err).WithField("mac", e.MAC).Errorf("invalid mac in node record")
+				continue
+			}
+
+			if len(e.IPs) == 0 {
+				r := NewMACIPRoute(sp.ASN, vni, mac, nil)
+				routes[r.Key()] = r
+			}
+
+			for _, sip := range e.IPs {
+				r := NewMACIPRoute(sp.ASN, vni, mac, net.ParseIP(sip))
+				routes[r.Key()] = r
+			}
+		}
+	}
+
+	return routes, nil
+}
+
+//runPeer connects to the peer, and reconnects after any failure, until done is closed
+func (sp *Speaker) runPeer(p *Peer, done <-chan struct{}) {
+	for {
+		s, err := dial(p, sp.ASN, sp.RouterID, sp.Vtep)
+		if err != nil {
+			log.WithError(err).WithField("peer", p.Address).Errorf("failed to establish session")
+		} else {
+			log.WithField("peer", p.Address).Infof("session established")
+			err = sp.serve(s, done)
+			log.WithError(err).WithField("peer", p.Address).Errorf("session closed")
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(DefaultConnectRetry):
+		}
+	}
+}
+
+//serve sends the local routes to an established session and installs the routes it receives
+//routes learned from the session are removed when it ends
+func (sp *Speaker) serve(s *session, done <-chan struct{}) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.keepalive(stop)
+	go func() {
+		select {
+		case <-done:
+			s.close()
+		case <-stop:
+		}
+	}()
+	go func() {
+		err := s.send(stop)
+		if err != nil {
+			//ends receive, and with it the session
+			log.WithError(err).WithField("peer", s.peer.Address).Errorf("failed to send update")
+			s.conn.Close()
+		}
+	}()
+
+	sp.mu.Lock()
+	var reach []*Route
+	for _, r := range sp.local {
+		reach = append(reach, r)
+	}
+	sp.sessions[s] = true
+	s.queue(reach, nil)
+	sp.mu.Unlock()
+
+	installed := make(map[string]*Route)
+	defer func() {
+		sp.mu.Lock()
+		delete(sp.sessions, s)
+		sp.mu.Unlock()
+		s.conn.Close()
+
+		for _, r := range installed {
+			uninstall(r)
+		}
+	}()
+
+	return s.receive(func(u *update) {
+		for _, r := range u.Unreach {
+			if old, ok := installed[r.Key()]; ok {
+				uninstall(old)
+				delete(installed, r.Key())
+			}
+		}
+
+		if !s.iBGP {
+			for _, as := range u.ASPath {
+				if as == sp.ASN {
+					return
+				}
+			}
+		}
+
+		for _, r := range u.Reach {
+			r.NextHop = u.NextHop
+			if r.NextHop.Equal(sp.Vtep) {
+				continue
+			}
+
+			if old, ok := installed[r.Key()]; ok {
+				uninstall(old)
+			}
+
+			err := install(r)
+			if err != nil {
+				log.WithError(err).WithField("route", r).Errorf("failed to install route")
+				continue
+			}
+			installed[r.Key()] = r
+		}
+	})
+}
+
+func install(r *Route) error {
+	log.WithField("route", r).Debugf("installing route")
+	index, ok := vxlanLinkByVNI(r.VNI)
+	if !ok {
+		return nil
+	}
+
+	if r.Type == RouteTypeInclusiveMulticast {
+		return netlink.NeighAppend(floodEntry(index, r))
+	}
+
+	err := netlink.NeighSet(fdbEntry(index, r))
+	if err != nil {
+		return err
+	}
+
+	if r.IP != nil {
+		return netlink.NeighSet(neighEntry(index, r))
+	}
+
+	return nil
+}
+
+func uninstall(r *Route) {
+	log.WithField("route", r).Debugf("removing route")
+	index, ok := vxlanLinkByVNI(r.VNI)
+	if !ok {
+		return
+	}
+
+	var err error
+	if r.Type == RouteTypeInclusiveMulticast {
+		err = netlink.NeighDel(floodEntry(index, r))
+	} else {
+		if r.IP != nil {
+			netlink.NeighDel(neighEntry(index, r))
+		}
+		err = netlink.NeighDel(fdbEntry(index, r))
+	}
+	if err != nil {
+		log.WithError(err).WithField("route", r).Debugf("failed to remove route")
+	}
+}
+
+func floodEntry(index int, r *Route) *netlink.Neigh {
+	return &netlink.Neigh{
+		LinkIndex:    index,
+		Family:       unix.AF_BRIDGE,
+		State:        netlink.NUD_PERMANENT,
+		Flags:        netlink.NTF_SELF,
+		IP:           r.OriginIP,
+		HardwareAddr: zeroMAC,
+	}
+}
+
+func fdbEntry(index int, r *Route) *netlink.Neigh {
+	return &netlink.Neigh{
+		LinkIndex:    index,
+		Family:       unix.AF_BRIDGE,
+		State:        netlink.NUD_PERMANENT,
+		Flags:        netlink.NTF_SELF,
+		IP:           r.NextHop,
+		HardwareAddr: r.MAC,
+	}
+}
+
+func neighEntry(index int, r *Route) *netlink.Neigh {
+	family := netlink.FAMILY_V6
+	if r.IP.To4() != nil {
+		family = netlink.FAMILY_V4
+	}
+
+	return &netlink.Neigh{
+		LinkIndex:    index,
+		Family:       family,
+		State:        netlink.NUD_PERMANENT,
+		IP:           r.IP,
+		HardwareAddr: r.MAC,
+	}
+}
+
+func vxlanLinkByVNI(vni uint32) (int, bool) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return 0, false
+	}
+
+	for _, l := range links {
+		vxl, ok := l.(*netlink.Vxlan)
+		if ok && uint32(vxl.VxlanId) == vni && strings.HasPrefix(vxl.Name, vxlan.VxlanLinkPrefix) {
+			return vxl.Index, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,143 @@
+package vxlan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//Firewall restricts the traffic of a container's interface, it is read from the FirewallAnnotation
+//a direction left out is not restricted, a direction with an empty list only allows replies to connections made in the other direction
+type Firewall struct {
+	Ingress []*FirewallRule `json:"ingress"`
+	Egress  []*FirewallRule `json:"egress"`
+}
+
+//FirewallRule allows traffic from (ingress) or to (egress) a cidr, optionally only to some ports
+//an empty cidr matches any address, ports are written as in a PolicyRule
+type FirewallRule struct {
+	Cidr  string   `json:"cidr"`
+	Ports []string `json:"ports"`
+}
+
+//ParseFirewall parses and validates the value of a FirewallAnnotation
+func ParseFirewall(s string) (*Firewall, error) {
+	fw := &Firewall{}
+	err := json.Unmarshal([]byte(s), fw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rules := range [][]*FirewallRule{fw.Ingress, fw.Egress} {
+		for _, r := range rules {
+			if r == nil {
+				return nil, fmt.Errorf("empty firewall rule")
+			}
+			if r.Cidr != "" {
+				if _, _, err := net.ParseCIDR(r.Cidr); err != nil {
+					return nil, err
+				}
+			}
+			for _, p := range r.Ports {
+				if _, _, err := parsePort(p); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return fw, nil
+}
+
+//firewallTable returns the nft table holding the rules for the container interface
+func firewallTable(ifname string) string {
+	return "vxlan_cni_" + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, ifname)
+}
+
+//firewallRules returns the nft matches allowing the rules' traffic, dir is saddr for ingress or daddr for egress
+func firewallRules(rules []*FirewallRule, dir string) []string {
+	var matches []string
+	for _, r := range rules {
+		var addr string
+		if r.Cidr != "" {
+			ip, n, _ := net.ParseCIDR(r.Cidr)
+			family := "ip6"
+			if ip.To4() != nil {
+				family = "ip"
+			}
+			addr = fmt.Sprintf("%v %v %v ", family, dir, n)
+		}
+
+		if len(r.Ports) == 0 {
+			matches = append(matches, addr+"accept")
+			continue
+		}
+
+		for _, p := range r.Ports {
+			proto, port, _ := parsePort(p)
+			matches = append(matches, fmt.Sprintf("%v%v dport %v accept", addr, proto, port))
+		}
+	}
+	return matches
+}
+
+//applyFirewall replaces the firewall of the interface, it must be called from within the container's namespace
+func applyFirewall(ifname string, fw *Firewall) error {
+	log.WithField("table", firewallTable(ifname)).Debugf("applying container firewall")
+	return nft(firewallScript(ifname, fw))
+}
+
+//firewallScript returns the nft script applyFirewall runs
+func firewallScript(ifname string, fw *Firewall) string {
+	table := firewallTable(ifname)
+	var b bytes.Buffer
+	//adding the table first lets the delete succeed whether or not it exists
+	fmt.Fprintf(&b, "add table inet %v\n", table)
+	fmt.Fprintf(&b, "delete table inet %v\n", table)
+	fmt.Fprintf(&b, "add table inet %v\n", table)
+
+	chains := []struct {
+		name, hook, iface, dir string
+		rules                  []*FirewallRule
+	}{
+		{"input", "input", "iifname", "saddr", fw.Ingress},
+		{"output", "output", "oifname", "daddr", fw.Egress},
+	}
+	for _, c := range chains {
+		if c.rules == nil {
+			continue
+		}
+
+		match := fmt.Sprintf("%v \"%v\"", c.iface, ifname)
+		fmt.Fprintf(&b, "add chain inet %v %v { type filter hook %v priority 0 ; policy accept ; }\n", table, c.name, c.hook)
+		fmt.Fprintf(&b, "add rule inet %v %v %v ct state established,related accept\n", table, c.name, match)
+		//ipv6 neighbor discovery stands in for arp, which the inet family never sees
+		fmt.Fprintf(&b, "add rule inet %v %v %v icmpv6 type { nd-neighbor-solicit, nd-neighbor-advert } accept\n", table, c.name, match)
+		for _, r := range firewallRules(c.rules, c.dir) {
+			fmt.Fprintf(&b, "add rule inet %v %v %v %v\n", table, c.name, match, r)
+		}
+		fmt.Fprintf(&b, "add rule inet %v %v %v drop\n", table, c.name, match)
+	}
+	return b.String()
+}
+
+//removeFirewall removes the firewall of the interface if there is one, it must be called from within the container's namespace
+func removeFirewall(ifname string) error {
+	//without nft no firewall could have been installed
+	if _, err := exec.LookPath(DefaultNftBin); err != nil {
+		return nil
+	}
+
+	table := firewallTable(ifname)
+	return nft(fmt.Sprintf("add table inet %v\ndelete table inet %v\n", table, table))
+}
@@ -0,0 +1,89 @@
+package vxlan
+
+import (
+	"testing"
+)
+
+func TestParseFirewall(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		ingress bool
+		egress  bool
+		err     bool
+	}{
+		{name: "ingress only", s: `{"ingress": [{"cidr": "10.1.0.0/16", "ports": ["tcp/443"]}]}`, ingress: true},
+		{name: "empty egress", s: `{"egress": []}`, egress: true},
+		{name: "both", s: `{"ingress": [{}], "egress": [{"cidr": "fd00::/64"}]}`, ingress: true, egress: true},
+		{name: "not json", s: `allow all`, err: true},
+		{name: "empty rule", s: `{"ingress": [null]}`, err: true},
+		{name: "invalid cidr", s: `{"egress": [{"cidr": "10.1.0.0"}]}`, err: true},
+		{name: "invalid port", s: `{"ingress": [{"ports": ["https"]}]}`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw, err := ParseFirewall(tt.s)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			//a direction left out is nil, and unrestricted, while an empty list restricts it
+			if (fw.Ingress != nil) != tt.ingress || (fw.Egress != nil) != tt.egress {
+				t.Errorf("expected ingress %v and egress %v to be restricted, got %+v", tt.ingress, tt.egress, fw)
+			}
+		})
+	}
+}
+
+func TestFirewallTable(t *testing.T) {
+	if got := firewallTable("net1.100-a"); got != "vxlan_cni_net1_100_a" {
+		t.Errorf("expected vxlan_cni_net1_100_a, got %v", got)
+	}
+}
+
+func TestFirewallScript(t *testing.T) {
+	fw, err := ParseFirewall(`{"ingress": [{"cidr": "10.1.0.0/16", "ports": ["tcp/443", "udp/53"]}, {"cidr": "fd00::/64"}]}`)
+	if err != nil {
+		t.Fatalf("failed to parse firewall: %v", err)
+	}
+
+	want := `add table inet vxlan_cni_eth0
+delete table inet vxlan_cni_eth0
+add table inet vxlan_cni_eth0
+add chain inet vxlan_cni_eth0 input { type filter hook input priority 0 ; policy accept ; }
+add rule inet vxlan_cni_eth0 input iifname "eth0" ct state established,related accept
+add rule inet vxlan_cni_eth0 input iifname "eth0" icmpv6 type { nd-neighbor-solicit, nd-neighbor-advert } accept
+add rule inet vxlan_cni_eth0 input iifname "eth0" ip saddr 10.1.0.0/16 tcp dport 443 accept
+add rule inet vxlan_cni_eth0 input iifname "eth0" ip saddr 10.1.0.0/16 udp dport 53 accept
+add rule inet vxlan_cni_eth0 input iifname "eth0" ip6 saddr fd00::/64 accept
+add rule inet vxlan_cni_eth0 input iifname "eth0" drop
+`
+	if got := firewallScript("eth0", fw); got != want {
+		t.Errorf("expected script\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestFirewallScriptEmptyEgress(t *testing.T) {
+	fw, err := ParseFirewall(`{"egress": []}`)
+	if err != nil {
+		t.Fatalf("failed to parse firewall: %v", err)
+	}
+
+	want := `add table inet vxlan_cni_eth0
+delete table inet vxlan_cni_eth0
+add table inet vxlan_cni_eth0
+add chain inet vxlan_cni_eth0 output { type filter hook output priority 0 ; policy accept ; }
+add rule inet vxlan_cni_eth0 output oifname "eth0" ct state established,related accept
+add rule inet vxlan_cni_eth0 output oifname "eth0" icmpv6 type { nd-neighbor-solicit, nd-neighbor-advert } accept
+add rule inet vxlan_cni_eth0 output oifname "eth0" drop
+`
+	if got := firewallScript("eth0", fw); got != want {
+		t.Errorf("expected script\n%v\ngot\n%v", want, got)
+	}
+}
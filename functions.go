@@ -1,12 +1,17 @@
 package vxlan
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net"
+
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 func getHostInterface(vxlan *Vxlan) (*HostInterface, error) {
 	var err error
-	vxName := "vx_" + vxlan.Name
+	vxName := VxlanLinkPrefix + vxlan.Name
 	mvName := "mv_" + vxlan.Name
 
 	hi := &HostInterface{
@@ -36,3 +41,81 @@ func linkIndexByName(name string) (int, error) {
 	}
 	return i, err
 }
+
+func firstLinkAddress(name string) (net.IP, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range addrs {
+		if a.IP.IsGlobalUnicast() {
+			return a.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no usable address on %v", name)
+}
+
+//DefaultRoute returns the default route destination for the address family of ip
+func DefaultRoute(ip net.IP) *net.IPNet {
+	if isIPv4(ip) {
+		return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	}
+	return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+}
+
+//IPVersion returns the CNI result version string for ip
+func IPVersion(ip net.IP) string {
+	if isIPv4(ip) {
+		return "4"
+	}
+	return "6"
+}
+
+func isIPv4(ip net.IP) bool {
+	return ip.To4() != nil
+}
+
+func hasIPv6(addrs []*net.IPNet) bool {
+	for _, a := range addrs {
+		if !isIPv4(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+//newAddr builds a netlink address, skipping duplicate address detection for v6
+//since every address on the vxlan is handed out by ipam
+func newAddr(addr *net.IPNet) *netlink.Addr {
+	a := &netlink.Addr{IPNet: addr}
+	if !isIPv4(addr.IP) {
+		a.Flags = unix.IFA_F_NODAD
+	}
+	return a
+}
+
+//setIPv6Sysctls enables ipv6 on the interface and disables router advertisements and DAD
+//it must be called from within the namespace containing the interface
+func setIPv6Sysctls(ifname string) error {
+	sysctls := map[string]string{
+		"disable_ipv6": "0",
+		"accept_ra":    "0",
+		"accept_dad":   "0",
+	}
+
+	for k, v := range sysctls {
+		err := ioutil.WriteFile("/proc/sys/net/ipv6/conf/"+ifname+"/"+k, []byte(v), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
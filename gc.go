@@ -0,0 +1,41 @@
+package vxlan
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+//DeleteStaleContainerLinks removes container macvlans that were left in the root namespace under their temporary name
+//it must be called while holding the vxlan's Lock, since ADD creates temporary links under the same lock
+func (hi *HostInterface) DeleteStaleContainerLinks() error {
+	log.Debugf("HostInterface.DeleteStaleContainerLinks()")
+	if hi.vxLink == nil {
+		return nil
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		if !strings.HasPrefix(l.Attrs().Name, TempLinkPrefix) || l.Attrs().ParentIndex != hi.vxLink.Attrs().Index {
+			continue
+		}
+
+		log.WithField("name", l.Attrs().Name).Debugf("deleting stale container link")
+		err = netlink.LinkDel(l)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//IsPresent returns true if any component of the host interface exists on the host
+func (hi *HostInterface) IsPresent() bool {
+	return hi.vxLink != nil || hi.mvLink != nil
+}
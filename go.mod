@@ -4,7 +4,6 @@ go 1.14
 
 require (
 	github.com/TrilliumIT/iputil v0.0.0-20180924135734-17ef68da6dff
-	github.com/alexflint/go-filemutex v1.1.0
 	github.com/imdario/mergo v0.3.9 // indirect
 	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
 	github.com/phdata/go-libcni v0.0.0-20200424184630-ef98665238ca
@@ -12,7 +11,7 @@ require (
 	github.com/vishvananda/netlink v1.1.0
 	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d // indirect
-	golang.org/x/sys v0.0.0-20200420163511-1957bb5e6d1f // indirect
+	golang.org/x/sys v0.0.0-20200420163511-1957bb5e6d1f
 	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1 // indirect
 	k8s.io/api v0.18.2 // indirect
 	k8s.io/apimachinery v0.18.2
@@ -1,17 +1,20 @@
 package vxlan
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"os"
 	"runtime"
 	"strconv"
-	"strings"
 
 	"github.com/TrilliumIT/iputil"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 )
 
 // HostInterface represents the host's connection to the vxlan
@@ -24,19 +27,42 @@ type HostInterface struct {
 	vxName      string
 	mvLink      netlink.Link
 	mvName      string
+	routing     *RoutingLock
+	mtu         int
 }
 
 // GetOrCreateHostInterface creates required host interfaces if they don't exist, or gets them if they already do
-func GetOrCreateHostInterface(vxlan *Vxlan) (*HostInterface, error) {
+// changes to the shared rules and route table are made while holding routing, which may be nil if the caller has no other writers to fear
+func GetOrCreateHostInterface(vxlan *Vxlan, routing *RoutingLock) (*HostInterface, error) {
 	hi, _ := getHostInterface(vxlan)
-	gateway := hi.GetGateway()
+	hi.routing = routing
+	gateways := hi.GetGateways()
 
-	if hi.vxLink != nil && hi.mvLink != nil && hi.hasAddress(gateway) {
+	if hi.vxLink != nil && hi.mvLink != nil && hi.hasAddresses(gateways) && hi.inVRF() {
 		log.Debugf("found existing host interface, returning")
-		return hi, nil
+		//the mtu follows the vtep device, and the policy may have changed since the network was brought up
+		err := hi.reconcileMTU()
+		if err != nil {
+			return hi, err
+		}
+		err = hi.routing.Do(hi.applyPolicy)
+		if err != nil {
+			return hi, err
+		}
+		err = hi.applyVxlanBandwidth()
+		if err != nil {
+			return hi, err
+		}
+		return hi, hi.ReconcileRemotes()
 	}
 
 	//host interface is incomplete, try to rebuild it
+	//the mtu is set on the links that exist, and used to create the rest
+	err := hi.reconcileMTU()
+	if err != nil {
+		return nil, err
+	}
+
 	if hi.vxLink == nil {
 		log.Debugf("%v interface nil, creating", hi.vxName)
 		err := hi.createVxlanLink()
@@ -47,61 +73,106 @@ func GetOrCreateHostInterface(vxlan *Vxlan) (*HostInterface, error) {
 
 	if hi.mvLink == nil {
 		log.Debugf("%v interface nil, creating", hi.mvName)
-		hmvl, err := hi.createMacvlanLink(hi.mvName)
+		hmvl, err := hi.createMacvlanLink(hi.mvName, netns.None(), nil)
 		if err != nil {
 			return nil, err
 		}
 
+		if hi.VxlanParams.VRF != "" {
+			err = hi.routing.Do(func() error {
+				return hi.enslaveVRF(hmvl)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		log.Debugf("initializing %v interface", hi.mvName)
-		err = hi.initializeMacvlanLink(hmvl, hi.GetGateway(), netns.None(), "")
+		err = hi.initializeMacvlanLink(hmvl, gateways, netns.None(), "", "", nil)
 		if err != nil {
 			return nil, err
 		}
 
 		hi.mvLink = hmvl
+	} else if !hi.inVRF() {
+		//missing addresses are added back below
+		err := hi.routing.Do(func() error {
+			return hi.enslaveVRF(hi.mvLink)
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	log.Debugf("validating/adding bypass route")
-	err := hi.checkOrAddBypassRoute()
-	if err != nil {
-		return hi, err
+	for _, gateway := range gateways {
+		if !hi.hasAddress(gateway) {
+			log.Debugf("%v interface missing gateway address %v, adding", hi.mvName, gateway)
+			err := netlink.AddrAdd(hi.mvLink, newAddr(gateway))
+			if err != nil {
+				return hi, err
+			}
+		}
+
+		if hi.VxlanParams.HasBypassRoute() {
+			err := hi.routing.Do(func() error {
+				log.Debugf("validating/adding bypass route")
+				err := hi.checkOrAddBypassRoute(gateway)
+				if err != nil {
+					return err
+				}
+
+				//in a vrf, the l3mdev rule already sends the vrf's traffic to its table
+				if hi.VxlanParams.VRF != "" {
+					return nil
+				}
+
+				log.Debugf("validating/adding bypass rule")
+				return hi.checkOrAddRule(gateway)
+			})
+			if err != nil {
+				return hi, err
+			}
+		}
 	}
 
-	log.Debugf("validating/adding bypass rule")
-	err = hi.checkOrAddRule()
+	log.Debugf("applying network policy")
+	err = hi.routing.Do(hi.applyPolicy)
 	if err != nil {
 		return hi, err
 	}
 
-	if !hi.hasAddress(gateway) {
-		log.Debugf("%v interface missing gateway address, adding", hi.mvName)
-		return hi, netlink.AddrAdd(hi.mvLink, &netlink.Addr{IPNet: hi.GetGateway()})
+	log.Debugf("applying vxlan bandwidth limit")
+	err = hi.applyVxlanBandwidth()
+	if err != nil {
+		return hi, err
 	}
 
-	return hi, nil
+	log.Debugf("reconciling remote vteps")
+	return hi, hi.ReconcileRemotes()
 }
 
-func (hi *HostInterface) checkOrAddRule() error {
+func (hi *HostInterface) checkOrAddRule(gateway *net.IPNet) error {
 	log.Debugf("checkOrAddRule()")
-	net := iputil.NetworkID(hi.GetGateway())
+	net := iputil.NetworkID(gateway)
 
-	rules, err := netlink.RuleList(0)
+	found, err := hi.hasRule(gateway)
 	if err != nil {
 		return err
 	}
 
-	for _, r := range rules {
-		if iputil.SubnetEqualSubnet(r.Src, net) && iputil.SubnetEqualSubnet(r.Dst, net) && r.Table == DefaultVxlanRouteTable {
-			log.Debugf("rule found, return")
-			return nil
-		}
+	if found {
+		log.Debugf("rule found, return")
+		return nil
 	}
 
 	log.Debugf("add rule")
 	rule := netlink.NewRule()
 	rule.Src = net
 	rule.Dst = net
-	rule.Table = DefaultVxlanRouteTable
+	rule.Table = hi.VxlanParams.GetRouteTable()
+	if hi.VxlanParams.RulePriority != 0 {
+		rule.Priority = hi.VxlanParams.RulePriority
+	}
 
 	err = netlink.RuleAdd(rule)
 	if err != nil {
@@ -112,27 +183,25 @@ func (hi *HostInterface) checkOrAddRule() error {
 	return nil
 }
 
-func (hi *HostInterface) checkOrAddBypassRoute() error {
+func (hi *HostInterface) checkOrAddBypassRoute(gateway *net.IPNet) error {
 	log.Debugf("checkOrAddBypassRoute()")
-	net := iputil.NetworkID(hi.GetGateway())
+	net := iputil.NetworkID(gateway)
 
-	routes, err := netlink.RouteListFiltered(0, &netlink.Route{Table: DefaultVxlanRouteTable}, netlink.RT_FILTER_TABLE)
+	found, err := hi.hasBypassRoute(gateway)
 	if err != nil {
 		return err
 	}
 
-	for _, r := range routes {
-		if iputil.SubnetEqualSubnet(r.Dst, net) && r.LinkIndex == hi.mvLink.Attrs().Index {
-			log.Debugf("bypass route found, return")
-			return nil
-		}
+	if found {
+		log.Debugf("bypass route found, return")
+		return nil
 	}
 
 	log.Debugf("add bypass route")
 	err = netlink.RouteAdd(&netlink.Route{
 		LinkIndex: hi.mvLink.Attrs().Index,
 		Dst:       net,
-		Table:     DefaultVxlanRouteTable,
+		Table:     hi.VxlanParams.GetRouteTable(),
 	})
 	if err != nil {
 		log.WithError(err).Errorf("failed to add vxlan bypass route")
@@ -142,6 +211,53 @@ func (hi *HostInterface) checkOrAddBypassRoute() error {
 	return nil
 }
 
+func (hi *HostInterface) hasRule(gateway *net.IPNet) (bool, error) {
+	net := iputil.NetworkID(gateway)
+
+	rules, err := netlink.RuleList(0)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range rules {
+		if !iputil.SubnetEqualSubnet(r.Src, net) || !iputil.SubnetEqualSubnet(r.Dst, net) || r.Table != hi.VxlanParams.GetRouteTable() {
+			continue
+		}
+		if hi.VxlanParams.RulePriority == 0 || r.Priority == hi.VxlanParams.RulePriority {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (hi *HostInterface) hasBypassRoute(gateway *net.IPNet) (bool, error) {
+	net := iputil.NetworkID(gateway)
+
+	routes, err := netlink.RouteListFiltered(0, &netlink.Route{Table: hi.VxlanParams.GetRouteTable()}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range routes {
+		if iputil.SubnetEqualSubnet(r.Dst, net) && r.LinkIndex == hi.mvLink.Attrs().Index {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (hi *HostInterface) hasAddresses(addrs []*net.IPNet) bool {
+	for _, a := range addrs {
+		if !hi.hasAddress(a) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (hi *HostInterface) hasAddress(addr *net.IPNet) bool {
 	addrs, _ := netlink.AddrList(hi.mvLink, 0)
 
@@ -158,7 +274,7 @@ func (hi *HostInterface) createVxlanLink() error {
 	nl := &netlink.Vxlan{
 		LinkAttrs: netlink.LinkAttrs{
 			Name: hi.vxName,
-			//MTU:  hi.VxlanParams.MTU,
+			MTU:  hi.mtu,
 		},
 		VxlanId: hi.VxlanParams.ID,
 	}
@@ -219,7 +335,7 @@ func (hi *HostInterface) createVxlanLink() error {
 		nl.PortLow, _ = strconv.Atoi(pl)
 	}
 	if ph, ok := hi.GetOption("porthigh"); ok {
-		nl.PortLow, _ = strconv.Atoi(ph)
+		nl.PortHigh, _ = strconv.Atoi(ph)
 	}
 
 	err := netlink.LinkAdd(nl)
@@ -237,14 +353,21 @@ func (hi *HostInterface) createVxlanLink() error {
 	return nil
 }
 
-func (hi *HostInterface) createMacvlanLink(name string) (*netlink.Macvlan, error) {
+//createMacvlanLink creates a macvlan on the vxlan link, in ns if it is open or else in the current namespace
+//with the mac address if it isn't nil, or else one the kernel picks
+func (hi *HostInterface) createMacvlanLink(name string, ns netns.NsHandle, mac net.HardwareAddr) (*netlink.Macvlan, error) {
 	nl := &netlink.Macvlan{
 		LinkAttrs: netlink.LinkAttrs{
-			Name:        name,
-			ParentIndex: hi.vxLink.Attrs().Index,
+			Name:         name,
+			ParentIndex:  hi.vxLink.Attrs().Index,
+			MTU:          hi.mtu,
+			HardwareAddr: mac,
 		},
 		Mode: netlink.MACVLAN_MODE_BRIDGE,
 	}
+	if ns.IsOpen() {
+		nl.Namespace = netlink.NsFd(int(ns))
+	}
 
 	var err error
 	err = netlink.LinkAdd(nl)
@@ -255,7 +378,10 @@ func (hi *HostInterface) createMacvlanLink(name string) (*netlink.Macvlan, error
 	return nl, nil
 }
 
-func (hi *HostInterface) initializeMacvlanLink(nl *netlink.Macvlan, addr *net.IPNet, ns netns.NsHandle, ifname string) error {
+//initializeMacvlanLink brings the link up with its addresses, moving it into ns first if one is given and it wasn't created there
+//along with the default routes and opts, which may be nil
+//a failure after the move deletes the link from ns, so no half configured interface is left behind
+func (hi *HostInterface) initializeMacvlanLink(nl *netlink.Macvlan, addrs []*net.IPNet, ns netns.NsHandle, containerID, ifname string, opts *ContainerLinkOptions) (err error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 	rootns, err := netns.Get()
@@ -264,46 +390,102 @@ func (hi *HostInterface) initializeMacvlanLink(nl *netlink.Macvlan, addr *net.IP
 	}
 	defer rootns.Close()
 
+	var link netlink.Link = nl
 	if ns.IsOpen() {
-		err = netlink.LinkSetNsFd(nl, int(ns))
-		if err != nil {
-			return err
+		if nl.Namespace == nil {
+			err = netlink.LinkSetNsFd(nl, int(ns))
+			if err != nil {
+				return err
+			}
 		}
 
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 
-		netns.Set(ns)
+		err = netns.Set(ns)
+		if err != nil {
+			return err
+		}
 		defer netns.Set(rootns)
 
-		err = netlink.LinkSetName(nl, ifname)
+		//the link keeps its name in the new namespace, but may be given a new index
+		link, err = netlink.LinkByName(nl.Name)
 		if err != nil {
 			return err
 		}
+		nl.Index = link.Attrs().Index
+
+		defer func() {
+			if err == nil {
+				return
+			}
+			log.WithError(err).WithField("link", link.Attrs().Name).Debugf("removing partially initialized container link")
+			if derr := netlink.LinkDel(link); derr != nil {
+				log.WithError(derr).Errorf("failed to remove partially initialized container link")
+			}
+		}()
+
+		err = netlink.LinkSetName(link, ifname)
+		if err != nil {
+			return err
+		}
+		link.Attrs().Name = ifname
+
+		if hasIPv6(addrs) {
+			// addresses are assigned by ipam and routes by us, so don't let the container autoconfigure or wait on DAD
+			err = setIPv6Sysctls(ifname)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	err = netlink.LinkSetUp(nl)
-	if err != nil {
-		return err
-	}
-
-	err = netlink.AddrAdd(nl, &netlink.Addr{IPNet: addr})
+	err = netlink.LinkSetUp(link)
 	if err != nil {
 		return err
 	}
 
-	if ns.IsOpen() {
-		// add default route through host to routing table in container namespace
-		_, defaultDst, _ := net.ParseCIDR("0.0.0.0/0")
-		err = netlink.RouteAdd(&netlink.Route{
-			Dst: defaultDst,
-			Gw:  hi.GetGateway().IP,
-		})
+	for _, addr := range addrs {
+		err = netlink.AddrAdd(link, newAddr(addr))
 		if err != nil {
 			return err
 		}
 	}
 
+	if ns.IsOpen() && opts != nil {
+		//route the requested destinations through the host
+		for _, dst := range opts.Routes {
+			gateway := hi.GetGatewayFor(dst.IP)
+			if gateway == nil {
+				continue
+			}
+
+			err = netlink.RouteAdd(&netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Dst:       dst,
+				Gw:        gateway.IP,
+			})
+			if err != nil && !os.IsExist(err) {
+				return err
+			}
+		}
+
+		fw := opts.Firewall
+		if fw != nil && (fw.Ingress != nil || fw.Egress != nil) {
+			err = applyFirewall(ifname, fw)
+			if err != nil {
+				return err
+			}
+		}
+
+		if opts.Bandwidth.IsLimited() {
+			err = applyBandwidth(link, containerID, ifname, opts.Bandwidth)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -313,44 +495,234 @@ func (hi *HostInterface) GetOption(opt string) (string, bool) {
 	return val, ok
 }
 
-//GetGateway gets the gateway address and subnet from the vxlan config
+//GetGateway gets the first gateway address and subnet from the vxlan config
 func (hi *HostInterface) GetGateway() *net.IPNet {
-	ipnet, _ := netlink.ParseIPNet(hi.VxlanParams.Cidr)
-	return ipnet
+	gateways := hi.GetGateways()
+	if len(gateways) == 0 {
+		return nil
+	}
+	return gateways[0]
 }
 
-//AddContainerLink adds a new macvlan link to the vxlan link, adds an IP, and puts it in the requested namespace.
-func (hi *HostInterface) AddContainerLink(namespace, ifname string, addr *net.IPNet) (int, error) {
-	cns, err := netns.GetFromPath(namespace)
-	defer cns.Close()
+//GetGateways gets every gateway address and subnet from the vxlan config
+func (hi *HostInterface) GetGateways() []*net.IPNet {
+	var gateways []*net.IPNet
+	for _, cidr := range hi.VxlanParams.GetCidrs() {
+		ipnet, err := netlink.ParseIPNet(cidr)
+		if err != nil {
+			log.WithError(err).WithField("cidr", cidr).Errorf("failed to parse vxlan cidr")
+			continue
+		}
+		gateways = append(gateways, ipnet)
+	}
+	return gateways
+}
+
+//GetGatewayFor gets the gateway in the same address family as ip
+func (hi *HostInterface) GetGatewayFor(ip net.IP) *net.IPNet {
+	for _, gateway := range hi.GetGateways() {
+		if isIPv4(gateway.IP) == isIPv4(ip) {
+			return gateway
+		}
+	}
+	return nil
+}
+
+//ContainerLinkOptions are the settings of a container link, MAC is set as the link is created and the rest from within its namespace
+//Routes are sent through the vxlan's gateway in their family, see ContainerRoutes, and MAC, if set, replaces the kernel's random address
+type ContainerLinkOptions struct {
+	MAC       net.HardwareAddr
+	Routes    []*net.IPNet
+	Firewall  *Firewall
+	Bandwidth *Bandwidth
+}
+
+//ContainerRoutes returns the destinations a container interface routes through the vxlan's gateways,
+//a default route for each of the vxlan's families if defaultRoute is set, and those of routes the vxlan has a gateway for
+func (hi *HostInterface) ContainerRoutes(defaultRoute bool, routes []*net.IPNet) []*net.IPNet {
+	var dsts []*net.IPNet
+	if defaultRoute {
+		for _, gateway := range hi.GetGateways() {
+			dsts = append(dsts, DefaultRoute(gateway.IP))
+		}
+	}
+
+	for _, dst := range routes {
+		if hi.GetGatewayFor(dst.IP) != nil {
+			dsts = append(dsts, dst)
+		}
+	}
+	return dsts
+}
+
+//TempLinkName returns the name a container link is created under before it is renamed to ifname in its namespace
+//it is derived from the attachment, so it is the same for a retried ADD and can't collide with another container's
+func TempLinkName(containerID, ifname string) string {
+	sum := sha256.Sum256([]byte(containerID + "/" + ifname))
+	return TempLinkPrefix + hex.EncodeToString(sum[:])[:MaxInterfaceName-len(TempLinkPrefix)]
+}
+
+//deleteTempLink removes a link named name from ns, or from the root namespace if ns isn't open, if one exists
+func deleteTempLink(name string, ns netns.NsHandle) error {
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+
+	stale, err := h.LinkByName(name)
 	if err != nil {
-		return -1, err
+		return nil
 	}
 
-	nsa := strings.Split(namespace, string(os.PathSeparator))
-	if len(nsa) < 3 {
-		return -1, fmt.Errorf("unexpected namespace path format")
+	log.WithField("tempName", name).Warnf("removing leftover temporary interface")
+	return h.LinkDel(stale)
+}
+
+//nsCreateUnsupported is whether err is the kernel refusing IFLA_NET_NS_FD on a new link, rather than the link itself failing
+func nsCreateUnsupported(err error) bool {
+	return err == unix.EOPNOTSUPP || err == unix.EINVAL
+}
+
+//AddContainerLink adds a new macvlan link to the vxlan link, adds the IPs, and puts it in the namespace at the path namespace.
+//opts, if not nil, are applied to the link from within the namespace
+func (hi *HostInterface) AddContainerLink(namespace, containerID, ifname string, addrs []*net.IPNet, opts *ContainerLinkOptions) error {
+	cns, err := netns.GetFromPath(namespace)
+	if err != nil {
+		return err
 	}
+	defer cns.Close()
 
-	//create interface with a temp name to prevent duplicates in the root namespace
-	tempName := "cmvl_" + nsa[2]
+	tempName := TempLinkName(containerID, ifname)
 	log.WithField("tempName", tempName).Debug("temporary interface name")
-	cmvl, err := hi.createMacvlanLink(tempName)
+	//we hold the network lock, so a link with this name is left over from an ADD that failed part way
+	for _, ns := range []netns.NsHandle{netns.None(), cns} {
+		err = deleteTempLink(tempName, ns)
+		if err != nil {
+			return err
+		}
+	}
+
+	var mac net.HardwareAddr
+	if opts != nil {
+		mac = opts.MAC
+	}
+
+	//creating the link directly in the namespace means it never appears in the root namespace,
+	//where the kernel doesn't allow that it is created there and moved
+	cmvl, err := hi.createMacvlanLink(tempName, cns, mac)
 	if err != nil {
-		return -1, err
+		if !nsCreateUnsupported(err) {
+			return err
+		}
+		log.WithError(err).Debugf("kernel can't create container link in its namespace, creating it in the root namespace")
+		cmvl, err = hi.createMacvlanLink(tempName, netns.None(), mac)
+		if err != nil {
+			return err
+		}
 	}
 
 	//set up, addr add, move to namespace
-	err = hi.initializeMacvlanLink(cmvl, addr, cns, ifname)
+	err = hi.initializeMacvlanLink(cmvl, addrs, cns, containerID, ifname, opts)
 	if err != nil {
-		return -1, err
+		//if the move failed the link is still in the root namespace
+		if l, lerr := netlink.LinkByName(tempName); lerr == nil {
+			netlink.LinkDel(l)
+		}
+		return err
 	}
 
-	return cmvl.Index, nil
+	return nil
 }
 
-//DeleteContainerLink deletes the containers interface
-func (hi *HostInterface) DeleteContainerLink(namespace, name string) error {
+//ContainerLink is a container interface found already attached to the vxlan
+type ContainerLink struct {
+	MAC   net.HardwareAddr
+	Addrs []*net.IPNet
+}
+
+//ExistingContainerLink returns the container's interface if a previous ADD already attached it to this vxlan,
+//with an address in every subnet of the vxlan and each of the routes, or nil if there is none
+//an interface on this vxlan missing any of those is left over from a failed ADD, and is deleted so ADD can start over,
+//its addresses in the vxlan's subnets are returned as removed, so the caller can release what that ADD allocated
+//an interface with the same name that does not belong to this vxlan is an error
+func (hi *HostInterface) ExistingContainerLink(namespace, name string, dsts []*net.IPNet) (cl *ContainerLink, removed []*net.IPNet, err error) {
+	log.WithFields(log.Fields{"namespace": namespace, "name": name}).Debugf("HostInterface.ExistingContainerLink()")
+	rootns, err := netns.Get()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rootns.Close()
+
+	cns, err := netns.GetFromPath(namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cns.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err = netns.Set(cns)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer netns.Set(rootns)
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if _, ok := link.(*netlink.Macvlan); !ok || link.Attrs().ParentIndex != hi.vxLink.Attrs().Index {
+		return nil, nil, fmt.Errorf("interface %v already exists in the container and is not attached to %v", name, hi.vxName)
+	}
+
+	linkAddrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cl = &ContainerLink{
+		MAC: link.Attrs().HardwareAddr,
+	}
+	complete := true
+	for _, gateway := range hi.GetGateways() {
+		addr := addrInSubnet(linkAddrs, gateway)
+		if addr == nil {
+			log.WithField("gateway", gateway).Warnf("removing incomplete container interface left by a previous ADD")
+			complete = false
+			continue
+		}
+		cl.Addrs = append(cl.Addrs, addr)
+	}
+
+	for _, dst := range dsts {
+		gateway := hi.GetGatewayFor(dst.IP)
+		if complete && gateway != nil && !containsRoute(routes, dst, gateway.IP) {
+			log.WithField("route", dst).Warnf("removing incomplete container interface left by a previous ADD")
+			complete = false
+		}
+	}
+
+	if !complete {
+		return nil, cl.Addrs, netlink.LinkDel(link)
+	}
+
+	return cl, nil, nil
+}
+
+//ReapplyContainerLink replaces the firewall and bandwidth limits of the container's existing interface with those in opts,
+//so a retried ADD returns an interface with the policy it asked for, even if the ADD that attached it failed before applying it
+func (hi *HostInterface) ReapplyContainerLink(namespace, containerID, name string, opts *ContainerLinkOptions) error {
 	rootns, err := netns.Get()
 	if err != nil {
 		return err
@@ -370,26 +742,268 @@ func (hi *HostInterface) DeleteContainerLink(namespace, name string) error {
 	if err != nil {
 		return err
 	}
+	defer netns.Set(rootns)
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	fw := opts.Firewall
+	if fw != nil && (fw.Ingress != nil || fw.Egress != nil) {
+		err = applyFirewall(name, fw)
+	} else {
+		err = removeFirewall(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = resetBandwidth(link, containerID)
+	if err != nil {
+		return err
+	}
+	if opts.Bandwidth.IsLimited() {
+		return applyBandwidth(link, containerID, name, opts.Bandwidth)
+	}
+	return nil
+}
+
+//addrInSubnet returns the first address in the gateway's subnet, other than the gateway itself
+func addrInSubnet(addrs []netlink.Addr, gateway *net.IPNet) *net.IPNet {
+	for _, a := range addrs {
+		if gateway.Contains(a.IP) && !a.IP.Equal(gateway.IP) {
+			return a.IPNet
+		}
+	}
+	return nil
+}
+
+//MACInUse reports whether mac is already known on the vxlan, as a forwarding entry of the vxlan link,
+//or as the address of the vxlan link or a macvlan on it in the root namespace
+//container links are in other namespaces, their addresses are in the attachment state
+func (hi *HostInterface) MACInUse(mac net.HardwareAddr) (bool, error) {
+	if hi.vxLink == nil {
+		return false, nil
+	}
+
+	fdb, err := netlink.NeighList(hi.vxLink.Attrs().Index, unix.AF_BRIDGE)
+	if err != nil {
+		return false, err
+	}
+	for _, n := range fdb {
+		if bytes.Equal(n.HardwareAddr, mac) {
+			return true, nil
+		}
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return false, err
+	}
+	for _, l := range links {
+		if l.Attrs().Index != hi.vxLink.Attrs().Index && l.Attrs().ParentIndex != hi.vxLink.Attrs().Index {
+			continue
+		}
+		if bytes.Equal(l.Attrs().HardwareAddr, mac) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//HostLinkName returns the name of the host's macvlan link on the vxlan
+func (hi *HostInterface) HostLinkName() string {
+	return hi.mvName
+}
+
+//HostLinkMAC returns the hardware address of the host's macvlan link
+//it is read from the kernel, as a link this invocation created doesn't know the address the kernel picked
+func (hi *HostInterface) HostLinkMAC() (net.HardwareAddr, error) {
+	link, err := netlink.LinkByName(hi.mvName)
+	if err != nil {
+		return nil, err
+	}
+	return link.Attrs().HardwareAddr, nil
+}
+
+//ContainerLinkMAC returns the hardware address of the container's interface
+func (hi *HostInterface) ContainerLinkMAC(namespace, name string) (net.HardwareAddr, error) {
+	rootns, err := netns.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rootns.Close()
+
+	cns, err := netns.GetFromPath(namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer cns.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err = netns.Set(cns)
+	if err != nil {
+		return nil, err
+	}
+	defer netns.Set(rootns)
 
 	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return link.Attrs().HardwareAddr, nil
+}
+
+//DeleteContainerLink deletes the containers interface, its firewall and its bandwidth limits
+func (hi *HostInterface) DeleteContainerLink(namespace, containerID, name string) error {
+	rootns, err := netns.Get()
 	if err != nil {
 		return err
 	}
+	defer rootns.Close()
 
-	err = netlink.LinkDel(link)
+	cns, err := netns.GetFromPath(namespace)
 	if err != nil {
 		return err
 	}
+	defer cns.Close()
 
-	return netns.Set(rootns)
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err = netns.Set(cns)
+	if err != nil {
+		return err
+	}
+	defer netns.Set(rootns)
+
+	err = removeFirewall(name)
+	if err != nil {
+		log.WithError(err).Errorf("failed to remove container firewall")
+	}
+
+	err = removeBandwidth(containerID, name)
+	if err != nil {
+		log.WithError(err).Errorf("failed to remove container bandwidth limits")
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	return netlink.LinkDel(link)
 }
 
 //Delete removes the components of the host interface from the host
 func (hi *HostInterface) Delete() error {
 	log.Debugf("HostInterface.Delete()")
-	//TODO:
-	//remove bypass rule
-	//remove bypass route
-	//delete vxlan (should cascade delete address and macvlan)
+
+	//nothing may have been installed yet, a failure here shouldn't keep the network from being torn down
+	err := hi.routing.Do(hi.removePolicy)
+	if err != nil {
+		log.WithError(err).Errorf("failed to remove network policy")
+	}
+
+	gateways := hi.GetGateways()
+	err = hi.routing.Do(func() error {
+		for _, gateway := range gateways {
+			log.Debugf("removing bypass rule")
+			err := hi.delRule(gateway)
+			if err != nil {
+				return err
+			}
+		}
+
+		if hi.mvLink == nil {
+			return nil
+		}
+
+		for _, gateway := range gateways {
+			log.Debugf("removing bypass route")
+			err := hi.delBypassRoute(gateway)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if hi.mvLink != nil {
+		log.Debugf("deleting %v interface", hi.mvName)
+		err := netlink.LinkDel(hi.mvLink)
+		if err != nil {
+			return err
+		}
+		hi.mvLink = nil
+	}
+
+	if hi.vxLink != nil {
+		log.Debugf("deleting %v interface", hi.vxName)
+		err := netlink.LinkDel(hi.vxLink)
+		if err != nil {
+			return err
+		}
+		hi.vxLink = nil
+	}
+
+	//the flood entries went with the vxlan interface
+	err = removeAddedRemotes(hi.VxlanParams.addedRemotesPath())
+	if err != nil {
+		log.WithError(err).Errorf("failed to remove record of added flood entries")
+	}
+
+	//other networks may share the vrf, it goes with the last of them
+	return hi.routing.Do(hi.deleteVRFIfUnused)
+}
+
+func (hi *HostInterface) delRule(gateway *net.IPNet) error {
+	found, err := hi.hasRule(gateway)
+	if err != nil || !found {
+		return err
+	}
+
+	net := iputil.NetworkID(gateway)
+	rule := netlink.NewRule()
+	rule.Src = net
+	rule.Dst = net
+	rule.Table = hi.VxlanParams.GetRouteTable()
+	if hi.VxlanParams.RulePriority != 0 {
+		rule.Priority = hi.VxlanParams.RulePriority
+	}
+
+	err = netlink.RuleDel(rule)
+	if err != nil {
+		log.WithError(err).Errorf("failed to delete rule")
+		return err
+	}
+
+	return nil
+}
+
+func (hi *HostInterface) delBypassRoute(gateway *net.IPNet) error {
+	found, err := hi.hasBypassRoute(gateway)
+	if err != nil || !found {
+		return err
+	}
+
+	err = netlink.RouteDel(&netlink.Route{
+		LinkIndex: hi.mvLink.Attrs().Index,
+		Dst:       iputil.NetworkID(gateway),
+		Table:     hi.VxlanParams.GetRouteTable(),
+	})
+	if err != nil {
+		log.WithError(err).Errorf("failed to delete vxlan bypass route")
+		return err
+	}
+
 	return nil
 }
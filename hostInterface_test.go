@@ -0,0 +1,59 @@
+package vxlan
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestTempLinkName(t *testing.T) {
+	tests := []struct {
+		containerID string
+		ifname      string
+	}{
+		{"", ""},
+		{"abc", "eth0"},
+		{"abc", "net1"},
+		{"abd", "eth0"},
+		{strings.Repeat("f", 64), "averylongifname"},
+	}
+
+	names := make(map[string]bool)
+	for _, tt := range tests {
+		name := TempLinkName(tt.containerID, tt.ifname)
+		if len(name) != MaxInterfaceName {
+			t.Errorf("TempLinkName(%q, %q) = %q, expected %v characters", tt.containerID, tt.ifname, name, MaxInterfaceName)
+		}
+		if !strings.HasPrefix(name, TempLinkPrefix) {
+			t.Errorf("TempLinkName(%q, %q) = %q, expected prefix %v", tt.containerID, tt.ifname, name, TempLinkPrefix)
+		}
+		if again := TempLinkName(tt.containerID, tt.ifname); again != name {
+			t.Errorf("TempLinkName(%q, %q) changed from %q to %q", tt.containerID, tt.ifname, name, again)
+		}
+		if names[name] {
+			t.Errorf("TempLinkName(%q, %q) = %q collides with another attachment", tt.containerID, tt.ifname, name)
+		}
+		names[name] = true
+	}
+}
+
+func TestNsCreateUnsupported(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{unix.EOPNOTSUPP, true},
+		{unix.EINVAL, true},
+		{unix.EEXIST, false},
+		{unix.EPERM, false},
+		{errors.New("operation not supported"), false},
+	}
+
+	for _, tt := range tests {
+		if got := nsCreateUnsupported(tt.err); got != tt.want {
+			t.Errorf("nsCreateUnsupported(%v) = %v, expected %v", tt.err, got, tt.want)
+		}
+	}
+}
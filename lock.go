@@ -1,42 +1,204 @@
 package vxlan
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/alexflint/go-filemutex"
+	"golang.org/x/sys/unix"
 )
 
 //Lock represents a filesystem based mutex on a whole vxlan
 //this allows us to effectively serialize any accesses to an individual network's interfaces
+//the holder's pid and the time it took the lock are written to the lock file, so a waiter can report who it is waiting on
 type Lock struct {
-	Name  string
-	mutex *filemutex.FileMutex
+	Name string
+	path string
+	file *os.File
+	held bool
 }
 
-//NewLock returns a new Lock
-func NewLock(name string) (*Lock, error) {
-	fm, err := filemutex.New(DefaultLockPath + string(os.PathSeparator) + "vxlan-" + name + DefaultLockExt)
+//LockHolder identifies the process holding a Lock
+type LockHolder struct {
+	PID   int
+	Since time.Time
+}
+
+//Exited reports whether the holder's process is gone, in which case its record is stale
+func (h *LockHolder) Exited() bool {
+	return syscall.Kill(h.PID, 0) == syscall.ESRCH
+}
+
+//LockError is returned when a Lock could not be acquired before its deadline
+type LockError struct {
+	Name   string
+	Holder *LockHolder
+}
+
+func (e *LockError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("timed out waiting for lock on network %v", e.Name)
+	}
+
+	msg := fmt.Sprintf("timed out waiting for lock on network %v held by pid %v for %v", e.Name, e.Holder.PID, time.Since(e.Holder.Since).Round(time.Second))
+	if e.Holder.Exited() {
+		msg += ", though that process has exited"
+	}
+	return msg
+}
+
+//NewLock returns a new Lock for the named vxlan in dir, or in DefaultLockPath if dir is empty
+func NewLock(dir, name string) (*Lock, error) {
+	return openLock(dir, name, "vxlan-"+name+DefaultLockExt)
+}
+
+func openLock(dir, name, file string) (*Lock, error) {
+	if dir == "" {
+		dir = DefaultLockPath
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	path := dir + string(os.PathSeparator) + file
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Lock{
-		Name:  name,
-		mutex: fm,
+		Name: name,
+		path: path,
+		file: f,
 	}, nil
 }
 
-//Lock acquires a lock on the vxlan
-func (l *Lock) Lock() {
-	l.mutex.Lock()
+//Lock acquires a lock on the vxlan, waiting as long as it takes
+func (l *Lock) Lock() error {
+	err := unix.Flock(int(l.file.Fd()), unix.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	l.held = true
+	l.writeHolder()
+	return nil
+}
+
+//TryLock acquires a lock on the vxlan, giving up with a *LockError at the deadline
+func (l *Lock) TryLock(deadline time.Time) error {
+	for {
+		err := unix.Flock(int(l.file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			l.held = true
+			l.writeHolder()
+			return nil
+		}
+		if err != unix.EWOULDBLOCK {
+			return err
+		}
+
+		if time.Now().After(deadline) {
+			return &LockError{Name: l.Name, Holder: l.Holder()}
+		}
+		time.Sleep(DefaultLockRetry)
+	}
+}
+
+//Holder returns the process holding the lock, or nil if it isn't recorded
+func (l *Lock) Holder() *LockHolder {
+	b, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return nil
+	}
+
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		return nil
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil
+	}
+
+	since, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	return &LockHolder{PID: pid, Since: time.Unix(since, 0)}
+}
+
+func (l *Lock) writeHolder() {
+	l.file.Truncate(0)
+	l.file.WriteAt([]byte(fmt.Sprintf("%v %v\n", os.Getpid(), time.Now().Unix())), 0)
 }
 
-//Unlock removes the lock on the vxlan
+//Unlock removes the lock on the vxlan, if this Lock holds it
+//the holder record is only cleared by the holder, another process's record is left for its waiters
 func (l *Lock) Unlock() {
-	l.mutex.Unlock()
+	if !l.held {
+		return
+	}
+	l.file.Truncate(0)
+	unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	l.held = false
 }
 
-//Close unlocks and closes the underlying file descriptor
+//Close unlocks the vxlan if this Lock holds it, and closes the underlying file descriptor
 func (l *Lock) Close() {
-	l.mutex.Close()
+	l.Unlock()
+	l.file.Close()
+}
+
+//RoutingLock is the host wide lock serializing changes to the rule list and the bypass route tables, which networks share
+//it is layered under the per network Lock: a network's Lock is always taken first, and the RoutingLock is only held
+//for the duration of a single change, never while waiting for a network's Lock, so the two can't deadlock
+type RoutingLock struct {
+	lock     *Lock
+	deadline time.Time
+}
+
+//NewRoutingLock returns the RoutingLock in dir, or in DefaultLockPath if dir is empty
+//every acquisition gives up at the deadline
+func NewRoutingLock(dir string, deadline time.Time) (*RoutingLock, error) {
+	//network names are never empty, so this can't collide with a network's lock file
+	l, err := openLock(dir, "routing", "vxlan"+DefaultLockExt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RoutingLock{
+		lock:     l,
+		deadline: deadline,
+	}, nil
+}
+
+//Do runs f while holding the lock, a nil RoutingLock runs f without locking
+func (rl *RoutingLock) Do(f func() error) error {
+	if rl == nil {
+		return f()
+	}
+
+	err := rl.lock.TryLock(rl.deadline)
+	if err != nil {
+		return err
+	}
+	defer rl.lock.Unlock()
+
+	return f()
+}
+
+//Close closes the underlying file descriptor, Do has already released the lock
+func (rl *RoutingLock) Close() {
+	if rl == nil {
+		return
+	}
+	rl.lock.file.Close()
 }
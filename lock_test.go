@@ -0,0 +1,52 @@
+package vxlan
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLockCloseLeavesHolderRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vxlan-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	holder, err := NewLock(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+
+	err = holder.TryLock(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("failed to take lock: %v", err)
+	}
+
+	//a waiter that gives up, then closes its lock, must not clear the holder's record
+	waiter, err := NewLock(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = waiter.TryLock(time.Now())
+	lerr, ok := err.(*LockError)
+	if !ok {
+		t.Fatalf("expected a *LockError, got %v", err)
+	}
+	if lerr.Holder == nil || lerr.Holder.PID != os.Getpid() {
+		t.Errorf("expected the holder to be pid %v, got %+v", os.Getpid(), lerr.Holder)
+	}
+	waiter.Unlock()
+	waiter.Close()
+
+	if h := holder.Holder(); h == nil || h.PID != os.Getpid() {
+		t.Errorf("expected the holder record to remain, got %+v", h)
+	}
+
+	holder.Unlock()
+	if h := holder.Holder(); h != nil {
+		t.Errorf("expected the holder record to be cleared on unlock, got %+v", h)
+	}
+}
@@ -0,0 +1,288 @@
+package vxlan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	lsyslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+const redacted = "REDACTED"
+
+//redactedFields are log fields whose values are never written to the log
+var redactedFields = []string{"annotations", "k8sConfigPath", "kubeconfig"}
+
+//ConfigureLogging sets up logrus from the config's log settings
+//the returned io.Closer closes the log file, if one was opened, on an error there is nothing to close
+func (c *Config) ConfigureLogging() (io.Closer, error) {
+	level := DefaultLogLevel
+	if c.LogLevel != "" {
+		level = c.LogLevel
+	}
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	log.SetLevel(lvl)
+
+	switch c.LogFormat {
+	case "", "text":
+		log.SetFormatter(&log.TextFormatter{DisableColors: true, FullTimestamp: true})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown logFormat %v", c.LogFormat)
+	}
+
+	log.AddHook(&redactHook{})
+
+	var closer io.Closer
+	switch {
+	case c.LogFile != "":
+		rf, err := NewRotatingFile(c.LogFile, int64(c.LogMaxSize)*1024*1024, c.LogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		log.SetOutput(rf)
+		closer = rf
+	case c.LogSink != "":
+		//the sink receives everything, don't duplicate it on stderr where the runtime would capture it
+		log.SetOutput(ioutil.Discard)
+	}
+
+	switch c.LogSink {
+	case "":
+	case "syslog":
+		var hook log.Hook
+		hook, err = lsyslog.NewSyslogHook("", "", syslog.LOG_DAEMON, DefaultLogIdentifier)
+		if err == nil {
+			log.AddHook(hook)
+		}
+	case "journald":
+		var hook log.Hook
+		hook, err = newJournaldHook()
+		if err == nil {
+			log.AddHook(hook)
+		}
+	default:
+		err = fmt.Errorf("unknown logSink %v", c.LogSink)
+	}
+	if err != nil {
+		//the log file isn't returned to be closed, so close it here
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, err
+	}
+
+	return closer, nil
+}
+
+//Redacted returns the raw config as json with sensitive values replaced, suitable for logging
+func (c *Config) Redacted() string {
+	m := make(map[string]interface{})
+	err := json.Unmarshal(c.raw, &m)
+	if err != nil {
+		return redacted
+	}
+
+	if _, ok := m["k8sConfigPath"]; ok {
+		m["k8sConfigPath"] = redacted
+	}
+	if args, ok := m["args"].(map[string]interface{}); ok {
+		if _, ok := args["annotations"]; ok {
+			args["annotations"] = redacted
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return redacted
+	}
+	return string(b)
+}
+
+//redactHook replaces the values of sensitive fields before the entry is written
+type redactHook struct{}
+
+func (h *redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *redactHook) Fire(e *log.Entry) error {
+	for _, f := range redactedFields {
+		if _, ok := e.Data[f]; ok {
+			e.Data[f] = redacted
+		}
+	}
+	return nil
+}
+
+//RotatingFile is a log file that is rotated once it grows beyond maxSize bytes, keeping maxBackups old files
+//a maxSize of 0 disables rotation
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mu         sync.Mutex
+}
+
+//NewRotatingFile opens path for appending, if it is already too large it is rotated on the first write
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.file = f
+	rf.size = fi.Size()
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+//rotate shifts path.N to path.N+1, dropping the oldest, and moves the current file to path.1
+//another plugin invocation may rotate concurrently, so missing files are not an error
+func (rf *RotatingFile) rotate() error {
+	rf.file.Close()
+
+	if rf.maxBackups <= 0 {
+		os.Remove(rf.path)
+	} else {
+		os.Remove(fmt.Sprintf("%v.%v", rf.path, rf.maxBackups))
+		for i := rf.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%v.%v", rf.path, i), fmt.Sprintf("%v.%v", rf.path, i+1))
+		}
+		os.Rename(rf.path, rf.path+".1")
+	}
+
+	return rf.open()
+}
+
+//Close closes the underlying file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
+
+//journaldHook sends entries to journald using its native datagram protocol
+type journaldHook struct {
+	conn *net.UnixConn
+}
+
+func newJournaldHook() (*journaldHook, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: DefaultJournaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldHook{conn: conn}, nil
+}
+
+func (h *journaldHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *journaldHook) Fire(e *log.Entry) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", e.Message)
+	writeJournalField(&b, "PRIORITY", fmt.Sprintf("%v", journalPriority(e.Level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", DefaultLogIdentifier)
+	for k, v := range e.Data {
+		writeJournalField(&b, journalFieldName(k), fmt.Sprintf("%v", v))
+	}
+
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+func writeJournalField(b *bytes.Buffer, k, v string) {
+	if !strings.Contains(v, "\n") {
+		fmt.Fprintf(b, "%v=%v\n", k, v)
+		return
+	}
+
+	//values containing newlines are written as the name, a newline, a little endian 64 bit length and the raw value
+	b.WriteString(k + "\n")
+	l := uint64(len(v))
+	for i := 0; i < 8; i++ {
+		b.WriteByte(byte(l >> (8 * uint(i))))
+	}
+	b.WriteString(v + "\n")
+}
+
+//journalFieldName converts a logrus field to a valid journald field name, upper case letters, digits and underscores
+func journalFieldName(k string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		}
+		return '_'
+	}, k)
+	return "VXLAN_" + name
+}
+
+func journalPriority(l log.Level) int {
+	switch l {
+	case log.PanicLevel:
+		return 0
+	case log.FatalLevel:
+		return 2
+	case log.ErrorLevel:
+		return 3
+	case log.WarnLevel:
+		return 4
+	case log.InfoLevel:
+		return 6
+	}
+	return 7
+}
@@ -0,0 +1,138 @@
+package vxlan
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+//underlayAddr returns an address the vxlan's traffic is sent from or to, which picks the underlay's device and family
+func (hi *HostInterface) underlayAddr() net.IP {
+	for _, opt := range []string{"srcaddr", "group"} {
+		if s, ok := hi.GetOption(opt); ok {
+			if ip := net.ParseIP(s); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	remotes, err := hi.VxlanParams.GetRemotes()
+	if err == nil && len(remotes) > 0 {
+		return remotes[0]
+	}
+	return nil
+}
+
+//vtepLink returns the underlay device the vxlan's traffic leaves through, the vtepdev option if set,
+//otherwise the device routing to the source address, group or first remote, or the device with the ipv4 default route
+func (hi *HostInterface) vtepLink() (netlink.Link, error) {
+	if vtep, ok := hi.GetOption("vtepdev"); ok {
+		return netlink.LinkByName(vtep)
+	}
+
+	dst := hi.underlayAddr()
+	if dst == nil {
+		return defaultRouteLink()
+	}
+
+	routes, err := netlink.RouteGet(dst)
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no route to underlay address %v", dst)
+	}
+
+	return netlink.LinkByIndex(routes[0].LinkIndex)
+}
+
+//defaultRouteLink returns the device of the main table's ipv4 default route
+func defaultRouteLink() (netlink.Link, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range routes {
+		if r.Dst == nil && r.LinkIndex > 0 {
+			return netlink.LinkByIndex(r.LinkIndex)
+		}
+	}
+	return nil, fmt.Errorf("no default route")
+}
+
+//overhead returns the bytes the vxlan encapsulation adds, which depends on the underlay's address family
+func (hi *HostInterface) overhead() int {
+	if ip := hi.underlayAddr(); ip != nil && ip.To4() == nil {
+		return VxlanOverheadV6
+	}
+	return VxlanOverheadV4
+}
+
+//linkMTU returns the mtu for the vxlan's links, the configured MTU or, if it isn't set, the vtep device's less the vxlan overhead
+//a configured MTU that wouldn't fit in the vtep device's mtu with the overhead is an error
+//if there is no vtep device to derive the mtu from it is 0, meaning unknown
+func (hi *HostInterface) linkMTU() (int, error) {
+	vtep, err := hi.vtepLink()
+	if err != nil {
+		if hi.VxlanParams.MTU > 0 {
+			log.WithError(err).Warnf("failed to find vtep device, using configured mtu unchecked")
+			return hi.VxlanParams.MTU, nil
+		}
+		log.WithError(err).Warnf("failed to find vtep device to derive mtu from, leaving mtu unchanged")
+		return 0, nil
+	}
+
+	max := vtep.Attrs().MTU - hi.overhead()
+	if hi.VxlanParams.MTU == 0 {
+		return max, nil
+	}
+
+	if hi.VxlanParams.MTU > max {
+		return 0, fmt.Errorf("mtu %v does not fit the %v byte vxlan overhead in the %v byte mtu of vtep device %v", hi.VxlanParams.MTU, hi.overhead(), vtep.Attrs().MTU, vtep.Attrs().Name)
+	}
+	return hi.VxlanParams.MTU, nil
+}
+
+//MTU returns the mtu of the vxlan's links, once the host interface has been created
+func (hi *HostInterface) MTU() int {
+	return hi.mtu
+}
+
+//reconcileMTU sets the mtu of whichever of the vxlan and host macvlan links exist, and the mtu new links are created with
+//when lowering it the macvlan goes first, so it never exceeds its parent
+//if the mtu is unknown the links are left alone, and new links take the vxlan link's mtu, or the kernel's default
+func (hi *HostInterface) reconcileMTU() error {
+	mtu, err := hi.linkMTU()
+	if err != nil {
+		return err
+	}
+	if mtu == 0 {
+		hi.mtu = 0
+		if hi.vxLink != nil {
+			hi.mtu = hi.vxLink.Attrs().MTU
+		}
+		return nil
+	}
+	hi.mtu = mtu
+
+	links := []netlink.Link{hi.vxLink, hi.mvLink}
+	if hi.vxLink != nil && hi.vxLink.Attrs().MTU > mtu {
+		links = []netlink.Link{hi.mvLink, hi.vxLink}
+	}
+
+	for _, l := range links {
+		if l == nil || l.Attrs().MTU == mtu {
+			continue
+		}
+		log.WithFields(log.Fields{"link": l.Attrs().Name, "mtu": mtu}).Debugf("setting mtu")
+		err = netlink.LinkSetMTU(l, mtu)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
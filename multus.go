@@ -0,0 +1,151 @@
+package vxlan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	cni "github.com/phdata/go-libcni"
+)
+
+//multusSelection is a network selection element of the MultusNetworksAnnotation
+type multusSelection struct {
+	Name         string   `json:"name"`
+	Namespace    string   `json:"namespace"`
+	IPs          []string `json:"ips"`
+	MAC          string   `json:"mac"`
+	Interface    string   `json:"interface"`
+	DefaultRoute []string `json:"default-route"`
+}
+
+//NetworkStatus describes one of a pod's interfaces in the MultusNetworkStatusAnnotation
+type NetworkStatus struct {
+	Name      string   `json:"name"`
+	Interface string   `json:"interface,omitempty"`
+	IPs       []string `json:"ips,omitempty"`
+	MAC       string   `json:"mac,omitempty"`
+	Default   bool     `json:"default,omitempty"`
+	DNS       *cni.DNS `json:"dns,omitempty"`
+}
+
+//ParseMultusSelections parses the value of a MultusNetworksAnnotation into a NetworkSelection for each of its elements, in order
+//it is either a json list of selection elements, or the short form of comma separated [namespace/]name[@interface]
+//an element without an interface is named net1, net2 and so on by its position, as Multus names it
+//a selection element's default-route only marks it as carrying the default route, the gateway is always the network's
+func ParseMultusSelections(s string) ([]*NetworkSelection, error) {
+	s = strings.TrimSpace(s)
+	var elems []*multusSelection
+	if strings.HasPrefix(s, "[") {
+		err := json.Unmarshal([]byte(s), &elems)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, e := range strings.Split(s, ",") {
+			elem := &multusSelection{Name: strings.TrimSpace(e)}
+			if i := strings.LastIndex(elem.Name, "@"); i >= 0 {
+				elem.Name, elem.Interface = elem.Name[:i], elem.Name[i+1:]
+			}
+			if i := strings.Index(elem.Name, "/"); i >= 0 {
+				elem.Namespace, elem.Name = elem.Name[:i], elem.Name[i+1:]
+			}
+			elems = append(elems, elem)
+		}
+	}
+
+	var sels []*NetworkSelection
+	for i, e := range elems {
+		if e == nil {
+			return nil, fmt.Errorf("network %v is empty", i)
+		}
+
+		dr := len(e.DefaultRoute) > 0
+		sel := &NetworkSelection{
+			Name:         e.Name,
+			Namespace:    e.Namespace,
+			Interface:    e.Interface,
+			MAC:          e.MAC,
+			DefaultRoute: &dr,
+		}
+		if sel.Interface == "" {
+			sel.Interface = fmt.Sprintf("net%v", i+1)
+		}
+		//multus ips carry a prefix length, the network decides it
+		for _, ip := range e.IPs {
+			sel.Addresses = append(sel.Addresses, strings.SplitN(ip, "/", 2)[0])
+		}
+		for _, gw := range e.DefaultRoute {
+			if net.ParseIP(gw) == nil {
+				return nil, fmt.Errorf("network %v: default-route %v is not an address", e.Name, gw)
+			}
+		}
+		sels = append(sels, sel)
+	}
+
+	return sels, nil
+}
+
+//MultusSelection returns the selection of this invocation from the value of a MultusNetworksAnnotation, or nil if there is none
+//Multus runs the plugin once for each element, with ifname set to the element's interface, so only the element on ifname
+//naming one of the configured vxlans is this invocation's, the rest are other invocations' or other plugins' and are ignored
+func (c *Config) MultusSelection(s, ifname string) (*NetworkSelection, error) {
+	sels, err := ParseMultusSelections(s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sel := range sels {
+		if sel.Interface != ifname || c.GetVxlan(sel.Name) == nil {
+			continue
+		}
+		_, err = resolveSelections([]*NetworkSelection{sel}, ifname)
+		if err != nil {
+			return nil, err
+		}
+		return sel, nil
+	}
+
+	return nil, nil
+}
+
+//MergeNetworkStatus returns the value of a MultusNetworkStatusAnnotation with statuses merged into existing,
+//each replacing the status of the same interface, and the rest of existing kept as they are, so other plugins' interfaces are not lost
+//an existing value that can't be parsed is replaced
+func MergeNetworkStatus(existing string, statuses []*NetworkStatus) (string, error) {
+	var merged []json.RawMessage
+	if strings.TrimSpace(existing) != "" {
+		err := json.Unmarshal([]byte(existing), &merged)
+		if err != nil {
+			merged = nil
+		}
+	}
+
+	for _, st := range statuses {
+		b, err := json.Marshal(st)
+		if err != nil {
+			return "", err
+		}
+
+		replaced := false
+		for i, m := range merged {
+			var other struct {
+				Interface string `json:"interface"`
+			}
+			if json.Unmarshal(m, &other) == nil && other.Interface != "" && other.Interface == st.Interface {
+				merged[i] = b
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, b)
+		}
+	}
+
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,153 @@
+package vxlan
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseMultusSelections(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want [][3]interface{}
+		err  bool
+	}{
+		{name: "short form", s: "front, kube-system/back@vx1, db",
+			want: [][3]interface{}{{"front", "net1", false}, {"back", "vx1", false}, {"db", "net3", false}}},
+		{name: "json", s: `[{"name": "front"}, {"name": "back", "namespace": "kube-system", "interface": "net1"}, {"name": "db"}]`,
+			want: [][3]interface{}{{"front", "net1", false}, {"back", "net1", false}, {"db", "net3", false}}},
+		{name: "default-route", s: `[{"name": "front"}, {"name": "back", "default-route": ["10.2.0.1"]}]`,
+			want: [][3]interface{}{{"front", "net1", false}, {"back", "net2", true}}},
+		{name: "other plugins' elements", s: `[{"name": "sriov", "mac": "not a mac", "ips": ["bogus"]}]`,
+			want: [][3]interface{}{{"sriov", "net1", false}}},
+		{name: "not json", s: `[front]`, err: true},
+		{name: "null element", s: `[null]`, err: true},
+		{name: "invalid default-route", s: `[{"name": "front", "default-route": ["10.2.0"]}]`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sels, err := ParseMultusSelections(tt.s)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %v", selectionSummary(sels))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := selectionSummary(sels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMultusSelection(t *testing.T) {
+	conf, err := NewConfig([]byte(`{
+		"vxlans": [
+			{"name": "front", "id": 1, "cidr": "10.1.0.1/16"},
+			{"name": "back", "id": 2, "cidr": "10.2.0.1/16"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		s      string
+		ifname string
+		want   string
+		err    bool
+	}{
+		{name: "by position", s: "sriov,front,back", ifname: "net2", want: "front"},
+		{name: "by interface", s: "sriov,front@vx1,back", ifname: "vx1", want: "front"},
+		{name: "another plugin's element", s: "sriov,front,back", ifname: "net1"},
+		{name: "the default network", s: "front,back", ifname: "eth0"},
+		{name: "another element invalid", s: `[{"name": "front"}, {"name": "back", "mac": "01:00:5e:00:00:01"}]`, ifname: "net1", want: "front"},
+		{name: "invalid mac", s: `[{"name": "front", "mac": "01:00:5e:00:00:01"}]`, ifname: "net1", err: true},
+		{name: "invalid ip", s: `[{"name": "front", "ips": ["10.1.0/16"]}]`, ifname: "net1", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := conf.MultusSelection(tt.s, tt.ifname)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", sel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got string
+			if sel != nil {
+				got = sel.Name
+			}
+			if got != tt.want {
+				t.Errorf("expected network %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseMultusSelectionsFields(t *testing.T) {
+	sels, err := ParseMultusSelections(`[{"name": "front", "namespace": "web", "ips": ["10.1.0.5/16", "fd01::5/64"], "mac": "02:00:00:00:00:05"}]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sel := sels[0]
+	if sel.StatusName() != "web/front" {
+		t.Errorf("expected status name web/front, got %v", sel.StatusName())
+	}
+	//the network decides the prefix length
+	if want := []string{"10.1.0.5", "fd01::5"}; !reflect.DeepEqual(sel.Addresses, want) {
+		t.Errorf("expected addresses %v, got %v", want, sel.Addresses)
+	}
+	if sel.HardwareAddr().String() != "02:00:00:00:00:05" {
+		t.Errorf("expected mac 02:00:00:00:00:05, got %v", sel.HardwareAddr())
+	}
+}
+
+func TestMergeNetworkStatus(t *testing.T) {
+	statuses := []*NetworkStatus{{Name: "web/front", Interface: "net1", IPs: []string{"10.1.0.5"}}}
+
+	tests := []struct {
+		name     string
+		existing string
+		want     string
+	}{
+		{"none", "", `[{"name":"web/front","interface":"net1","ips":["10.1.0.5"]}]`},
+		{"unparseable", "{", `[{"name":"web/front","interface":"net1","ips":["10.1.0.5"]}]`},
+		{"others kept as they are",
+			`[{"name":"cluster","interface":"eth0","ips":["10.0.0.5"],"default":true,"gateway":["10.0.0.1"]}]`,
+			`[{"name":"cluster","interface":"eth0","ips":["10.0.0.5"],"default":true,"gateway":["10.0.0.1"]},{"name":"web/front","interface":"net1","ips":["10.1.0.5"]}]`},
+		{"same interface replaced",
+			`[{"name":"cluster","interface":"eth0"},{"name":"web/front","interface":"net1","ips":["10.1.0.9"]},{"name":"sriov","interface":"net2"}]`,
+			`[{"name":"cluster","interface":"eth0"},{"name":"web/front","interface":"net1","ips":["10.1.0.5"]},{"name":"sriov","interface":"net2"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeNetworkStatus(tt.existing, statuses)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var gotv, wantv interface{}
+			if err := json.Unmarshal([]byte(got), &gotv); err != nil {
+				t.Fatalf("merged status is not json: %v", err)
+			}
+			json.Unmarshal([]byte(tt.want), &wantv)
+			if !reflect.DeepEqual(gotv, wantv) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
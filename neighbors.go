@@ -0,0 +1,187 @@
+package vxlan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//NodeRecord lists the containers on one vxlan that are reachable through a node's VTEP
+//each node writes one record per network into a directory shared across the cluster
+type NodeRecord struct {
+	Node    string            `json:"node"`
+	Network string            `json:"network"`
+	Vtep    string            `json:"vtep"`
+	Entries []*NeighborRecord `json:"entries"`
+}
+
+//NeighborRecord is a single container interface in a NodeRecord
+type NeighborRecord struct {
+	MAC string   `json:"mac"`
+	IPs []string `json:"ips"`
+}
+
+func nodeRecordPath(dir, node, network string) string {
+	return filepath.Join(dir, node+"_"+network+DefaultRecordExt)
+}
+
+//LoadNodeRecords reads every node record in dir
+func LoadNodeRecords(dir string) ([]*NodeRecord, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*NodeRecord
+	for _, i := range infos {
+		if !i.Mode().IsRegular() || !strings.HasSuffix(i.Name(), DefaultRecordExt) {
+			continue
+		}
+
+		rec, err := readNodeRecord(filepath.Join(dir, i.Name()))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+func readNodeRecord(path string) (*NodeRecord, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &NodeRecord{}
+	err = json.Unmarshal(b, rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node record %v: %v", path, err)
+	}
+
+	return rec, nil
+}
+
+func writeNodeRecord(dir string, rec *NodeRecord) error {
+	path := nodeRecordPath(dir, rec.Node, rec.Network)
+	if len(rec.Entries) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+//PublishNeighbor adds the container's mac and addresses to this node's record for the vxlan
+//it must be called while holding the vxlan's Lock
+func (hi *HostInterface) PublishNeighbor(dir string, mac net.HardwareAddr, addrs []*net.IPNet) error {
+	vtep, err := hi.LocalVtep()
+	if err != nil {
+		return err
+	}
+
+	node, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	var ips []string
+	for _, a := range addrs {
+		ips = append(ips, a.IP.String())
+	}
+
+	rec, err := readNodeRecord(nodeRecordPath(dir, node, hi.VxlanParams.Name))
+	if os.IsNotExist(err) {
+		rec, err = &NodeRecord{Node: node, Network: hi.VxlanParams.Name}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rec.Vtep = vtep.String()
+	rec.Entries = removeNeighborRecords(rec.Entries, mac.String(), ips)
+	rec.Entries = append(rec.Entries, &NeighborRecord{MAC: mac.String(), IPs: ips})
+
+	return writeNodeRecord(dir, rec)
+}
+
+//UnpublishNeighbor removes any entry holding one of the addresses from this node's record for the vxlan
+//it must be called while holding the vxlan's Lock
+func (hi *HostInterface) UnpublishNeighbor(dir string, ips []net.IP) error {
+	node, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	rec, err := readNodeRecord(nodeRecordPath(dir, node, hi.VxlanParams.Name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var sips []string
+	for _, ip := range ips {
+		sips = append(sips, ip.String())
+	}
+
+	rec.Entries = removeNeighborRecords(rec.Entries, "", sips)
+	return writeNodeRecord(dir, rec)
+}
+
+func removeNeighborRecords(entries []*NeighborRecord, mac string, ips []string) []*NeighborRecord {
+	var remaining []*NeighborRecord
+	for _, e := range entries {
+		if (mac != "" && e.MAC == mac) || containsString(e.IPs, ips...) {
+			continue
+		}
+		remaining = append(remaining, e)
+	}
+	return remaining
+}
+
+func containsString(list []string, s ...string) bool {
+	for _, l := range list {
+		for _, i := range s {
+			if l == i {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//LocalVtep returns the underlay address other nodes use to reach this node's vxlan interface
+//it is the srcaddr option if set, otherwise the first address on the vtepdev
+func (hi *HostInterface) LocalVtep() (net.IP, error) {
+	if srcaddr, ok := hi.GetOption("srcaddr"); ok {
+		return net.ParseIP(srcaddr), nil
+	}
+
+	vtep, ok := hi.GetOption("vtepdev")
+	if !ok {
+		return nil, fmt.Errorf("network %v has neither a srcaddr nor a vtepdev option to find the local vtep", hi.VxlanParams.Name)
+	}
+
+	return firstLinkAddress(vtep)
+}
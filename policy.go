@@ -0,0 +1,261 @@
+package vxlan
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/TrilliumIT/iputil"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+//Policy controls which networks may route to each other through the host
+//rules are matched in order, traffic between networks matching no rule gets the Default action
+type Policy struct {
+	Default string        `json:"default"`
+	Rules   []*PolicyRule `json:"rules"`
+}
+
+//PolicyRule allows or denies traffic from one network to another, optionally only to some ports
+//ports are written proto/port or proto/low-high, e.g. tcp/443 or udp/8000-8100
+type PolicyRule struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Ports  []string `json:"ports"`
+	Action string   `json:"action"`
+}
+
+//networkPolicy is the list of nft rules enforcing the Policy on traffic leaving one network
+type networkPolicy struct {
+	rules []string
+}
+
+func verdict(action string) string {
+	if action == "deny" {
+		return "drop"
+	}
+	return "accept"
+}
+
+//networkCidrs returns the network addresses of the vxlan's cidrs
+func networkCidrs(v *Vxlan) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range v.GetCidrs() {
+		ipnet, err := netlink.ParseIPNet(cidr)
+		if err != nil {
+			continue
+		}
+		nets = append(nets, iputil.NetworkID(ipnet))
+	}
+	return nets
+}
+
+//daddr returns the nft match on the destination network, in the network's address family
+func daddr(n *net.IPNet) string {
+	if n.IP.To4() != nil {
+		return "ip daddr " + n.String()
+	}
+	return "ip6 daddr " + n.String()
+}
+
+//parsePort splits proto/port or proto/low-high into the protocol and nft port expression
+func parsePort(p string) (string, string, error) {
+	parts := strings.SplitN(p, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("port %v must be written proto/port", p)
+	}
+
+	proto := parts[0]
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return "", "", fmt.Errorf("port %v protocol must be tcp, udp or sctp", p)
+	}
+
+	bounds := strings.SplitN(parts[1], "-", 2)
+	for _, b := range bounds {
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 1 || n > 65535 {
+			return "", "", fmt.Errorf("port %v is not a port or range of ports 1-65535", p)
+		}
+	}
+	if len(bounds) == 2 {
+		lo, _ := strconv.Atoi(bounds[0])
+		hi, _ := strconv.Atoi(bounds[1])
+		if lo > hi {
+			return "", "", fmt.Errorf("port range %v is reversed", p)
+		}
+	}
+
+	return proto, parts[1], nil
+}
+
+//policyFor builds the rules for traffic leaving the vxlan, from the policy rules naming it as the source
+//followed by the default action for every other network
+func (c *Config) policyFor(v *Vxlan) *networkPolicy {
+	networks := make(map[string]*Vxlan)
+	for _, o := range c.Vxlans {
+		if o != nil {
+			networks[o.Name] = o
+		}
+	}
+
+	np := &networkPolicy{}
+	for _, r := range c.Policy.Rules {
+		to, ok := networks[r.To]
+		if r.From != v.Name || !ok || to == v {
+			continue
+		}
+
+		for _, n := range networkCidrs(to) {
+			if len(r.Ports) == 0 {
+				np.rules = append(np.rules, daddr(n)+" "+verdict(r.Action))
+				continue
+			}
+
+			for _, p := range r.Ports {
+				proto, port, err := parsePort(p)
+				if err != nil {
+					continue
+				}
+				np.rules = append(np.rules, fmt.Sprintf("%v %v dport %v %v", daddr(n), proto, port, verdict(r.Action)))
+			}
+		}
+	}
+
+	for _, o := range c.Vxlans {
+		if o == nil || o == v {
+			continue
+		}
+		for _, n := range networkCidrs(o) {
+			np.rules = append(np.rules, daddr(n)+" "+verdict(c.Policy.Default))
+		}
+	}
+
+	return np
+}
+
+func (hi *HostInterface) policyChain() string {
+	return "from_" + hi.VxlanParams.Name
+}
+
+//applyPolicy replaces the nft rules for traffic leaving the vxlan's macvlan in a single transaction
+//return traffic for established connections is always allowed, and traffic to anything other than a configured network is not touched
+//a vxlan no longer under a policy has the rules an earlier config installed removed
+func (hi *HostInterface) applyPolicy() error {
+	if hi.VxlanParams.policy == nil {
+		return hi.removePolicy()
+	}
+
+	log.WithField("chain", hi.policyChain()).Debugf("applying network policy")
+	err := nft(hi.policyScript())
+	if err != nil {
+		return err
+	}
+
+	//the network's own policy is in place, leftovers of others shouldn't fail its ADD
+	err = removeStalePolicies()
+	if err != nil {
+		log.WithError(err).Warnf("failed to remove stale network policies")
+	}
+	return nil
+}
+
+//removeStalePolicies removes the policy chains of networks whose macvlan is gone,
+//left behind when a network was removed from the config while it was still up
+func removeStalePolicies() error {
+	out, err := exec.Command(DefaultNftBin, "list", "table", "inet", DefaultPolicyTable).Output()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range policyNetworks(string(out)) {
+		hi := &HostInterface{VxlanParams: &Vxlan{Name: name}, mvName: "mv_" + name}
+		if _, err := netlink.LinkByName(hi.mvName); err == nil {
+			continue
+		}
+
+		log.WithField("chain", hi.policyChain()).Infof("removing policy of a network that is gone")
+		err = nft(hi.removePolicyScript())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//policyNetworks returns the networks with a policy chain in an nft listing of the policy table
+func policyNetworks(listing string) []string {
+	var names []string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "chain" && strings.HasPrefix(fields[1], "from_") {
+			names = append(names, strings.TrimPrefix(fields[1], "from_"))
+		}
+	}
+	return names
+}
+
+//policyScript returns the nft script applyPolicy runs
+func (hi *HostInterface) policyScript() string {
+	chain := hi.policyChain()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "add table inet %v\n", DefaultPolicyTable)
+	fmt.Fprintf(&b, "add map inet %v from_networks { type ifname : verdict ; }\n", DefaultPolicyTable)
+	fmt.Fprintf(&b, "add chain inet %v forward { type filter hook forward priority 0 ; policy accept ; }\n", DefaultPolicyTable)
+	fmt.Fprintf(&b, "flush chain inet %v forward\n", DefaultPolicyTable)
+	fmt.Fprintf(&b, "add rule inet %v forward ct state established,related accept\n", DefaultPolicyTable)
+	fmt.Fprintf(&b, "add rule inet %v forward iifname vmap @from_networks\n", DefaultPolicyTable)
+	fmt.Fprintf(&b, "add chain inet %v %v\n", DefaultPolicyTable, chain)
+	fmt.Fprintf(&b, "flush chain inet %v %v\n", DefaultPolicyTable, chain)
+	for _, r := range hi.VxlanParams.policy.rules {
+		fmt.Fprintf(&b, "add rule inet %v %v %v\n", DefaultPolicyTable, chain, r)
+	}
+	fmt.Fprintf(&b, "add element inet %v from_networks { \"%v\" : jump %v }\n", DefaultPolicyTable, hi.mvName, chain)
+	return b.String()
+}
+
+//removePolicy removes the vxlan's policy chain, the table and forward chain are left for other networks
+//a vxlan without a policy is checked for a chain left by an earlier config, without needing nft installed if there is none
+func (hi *HostInterface) removePolicy() error {
+	if hi.VxlanParams.policy == nil && !hi.hasPolicyChain() {
+		return nil
+	}
+
+	log.WithField("chain", hi.policyChain()).Debugf("removing network policy")
+	return nft(hi.removePolicyScript())
+}
+
+//hasPolicyChain reports whether the vxlan's policy chain is installed
+func (hi *HostInterface) hasPolicyChain() bool {
+	return exec.Command(DefaultNftBin, "list", "chain", "inet", DefaultPolicyTable, hi.policyChain()).Run() == nil
+}
+
+//removePolicyScript returns the nft script removePolicy runs
+//the chain and map element are added before they are deleted, so the script succeeds whichever of them is installed
+func (hi *HostInterface) removePolicyScript() string {
+	chain := hi.policyChain()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "add table inet %v\n", DefaultPolicyTable)
+	fmt.Fprintf(&b, "add map inet %v from_networks { type ifname : verdict ; }\n", DefaultPolicyTable)
+	fmt.Fprintf(&b, "add chain inet %v %v\n", DefaultPolicyTable, chain)
+	fmt.Fprintf(&b, "add element inet %v from_networks { \"%v\" : jump %v }\n", DefaultPolicyTable, hi.mvName, chain)
+	fmt.Fprintf(&b, "delete element inet %v from_networks { \"%v\" }\n", DefaultPolicyTable, hi.mvName)
+	fmt.Fprintf(&b, "flush chain inet %v %v\n", DefaultPolicyTable, chain)
+	fmt.Fprintf(&b, "delete chain inet %v %v\n", DefaultPolicyTable, chain)
+	return b.String()
+}
+
+//nft runs the script with nft as a single atomic transaction
+func nft(script string) error {
+	cmd := exec.Command(DefaultNftBin, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nft failed: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
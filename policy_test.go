@@ -0,0 +1,168 @@
+package vxlan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		port  string
+		proto string
+		expr  string
+		err   bool
+	}{
+		{port: "tcp/443", proto: "tcp", expr: "443"},
+		{port: "udp/8000-8100", proto: "udp", expr: "8000-8100"},
+		{port: "sctp/1", proto: "sctp", expr: "1"},
+		{port: "443", err: true},
+		{port: "icmp/1", err: true},
+		{port: "tcp/0", err: true},
+		{port: "tcp/65536", err: true},
+		{port: "tcp/http", err: true},
+		{port: "tcp/100-10", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.port, func(t *testing.T) {
+			proto, expr, err := parsePort(tt.port)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if proto != tt.proto || expr != tt.expr {
+				t.Errorf("expected %v %v, got %v %v", tt.proto, tt.expr, proto, expr)
+			}
+		})
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	conf, err := NewConfig([]byte(`{
+		"vxlans": [
+			{"name": "front", "id": 1, "cidr": "10.1.0.1/16"},
+			{"name": "back", "id": 2, "cidrs": ["10.2.0.1/16", "fd02::1/64"]},
+			{"name": "db", "id": 3, "cidr": "10.3.0.1/16"}
+		],
+		"policy": {
+			"default": "deny",
+			"rules": [
+				{"from": "front", "to": "back", "ports": ["tcp/443", "tcp/8000-8100"]},
+				{"from": "back", "to": "db", "action": "allow"},
+				{"from": "front", "to": "db", "action": "deny"}
+			]
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	tests := []struct {
+		network string
+		rules   []string
+	}{
+		{"front", []string{
+			"ip daddr 10.2.0.0/16 tcp dport 443 accept",
+			"ip daddr 10.2.0.0/16 tcp dport 8000-8100 accept",
+			"ip6 daddr fd02::/64 tcp dport 443 accept",
+			"ip6 daddr fd02::/64 tcp dport 8000-8100 accept",
+			"ip daddr 10.3.0.0/16 drop",
+			"ip daddr 10.2.0.0/16 drop",
+			"ip6 daddr fd02::/64 drop",
+			"ip daddr 10.3.0.0/16 drop",
+		}},
+		{"back", []string{
+			"ip daddr 10.3.0.0/16 accept",
+			"ip daddr 10.1.0.0/16 drop",
+			"ip daddr 10.3.0.0/16 drop",
+		}},
+		{"db", []string{
+			"ip daddr 10.1.0.0/16 drop",
+			"ip daddr 10.2.0.0/16 drop",
+			"ip6 daddr fd02::/64 drop",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			v := conf.GetVxlan(tt.network)
+			if v.policy == nil {
+				t.Fatalf("expected a policy")
+			}
+			if !reflect.DeepEqual(v.policy.rules, tt.rules) {
+				t.Errorf("expected rules\n%v\ngot\n%v", strings.Join(tt.rules, "\n"), strings.Join(v.policy.rules, "\n"))
+			}
+		})
+	}
+}
+
+func TestPolicyScript(t *testing.T) {
+	hi := &HostInterface{
+		VxlanParams: &Vxlan{Name: "front", policy: &networkPolicy{rules: []string{"ip daddr 10.2.0.0/16 accept"}}},
+		mvName:      "mv_front",
+	}
+
+	want := `add table inet vxlan_cni
+add map inet vxlan_cni from_networks { type ifname : verdict ; }
+add chain inet vxlan_cni forward { type filter hook forward priority 0 ; policy accept ; }
+flush chain inet vxlan_cni forward
+add rule inet vxlan_cni forward ct state established,related accept
+add rule inet vxlan_cni forward iifname vmap @from_networks
+add chain inet vxlan_cni from_front
+flush chain inet vxlan_cni from_front
+add rule inet vxlan_cni from_front ip daddr 10.2.0.0/16 accept
+add element inet vxlan_cni from_networks { "mv_front" : jump from_front }
+`
+	if got := hi.policyScript(); got != want {
+		t.Errorf("expected script\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestRemovePolicyScript(t *testing.T) {
+	hi := &HostInterface{VxlanParams: &Vxlan{Name: "front"}, mvName: "mv_front"}
+
+	want := `add table inet vxlan_cni
+add map inet vxlan_cni from_networks { type ifname : verdict ; }
+add chain inet vxlan_cni from_front
+add element inet vxlan_cni from_networks { "mv_front" : jump from_front }
+delete element inet vxlan_cni from_networks { "mv_front" }
+flush chain inet vxlan_cni from_front
+delete chain inet vxlan_cni from_front
+`
+	if got := hi.removePolicyScript(); got != want {
+		t.Errorf("expected script\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestPolicyNetworks(t *testing.T) {
+	listing := `table inet vxlan_cni {
+	map from_networks {
+		type ifname : verdict
+		elements = { "mv_front" : jump from_front }
+	}
+
+	chain forward {
+		type filter hook forward priority filter; policy accept;
+		ct state established,related accept
+		iifname vmap @from_networks
+	}
+
+	chain from_front {
+		ip daddr 10.2.0.0/16 accept
+	}
+
+	chain from_back_end {
+	}
+}
+`
+	want := []string{"front", "back_end"}
+	if got := policyNetworks(listing); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
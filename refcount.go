@@ -0,0 +1,137 @@
+package vxlan
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//RefCount tracks the attachments to a vxlan so the host interface can be removed when the last one leaves
+//a container may be attached to the same vxlan on several interfaces, so each attachment is counted
+//it is not safe for concurrent use, and should only be accessed while holding the vxlan's Lock
+type RefCount struct {
+	Name string
+	path string
+}
+
+//NewRefCount returns a new RefCount for the named vxlan in dir, or in DefaultLockPath if dir is empty
+func NewRefCount(dir, name string) *RefCount {
+	if dir == "" {
+		dir = DefaultLockPath
+	}
+
+	return &RefCount{
+		Name: name,
+		path: dir + string(os.PathSeparator) + "vxlan-" + name + DefaultRefCountExt,
+	}
+}
+
+//refKey is how the attachment is recorded, interface names can't contain a /
+func refKey(a Attachment) string {
+	return a.ContainerID + "/" + a.IfName
+}
+
+//Add records the attachment to the vxlan and returns the new count
+func (rc *RefCount) Add(a Attachment) (int, error) {
+	id := refKey(a)
+	ids, err := rc.read()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, i := range ids {
+		if i == id {
+			return len(ids), nil
+		}
+	}
+
+	ids = append(ids, id)
+	return len(ids), rc.write(ids)
+}
+
+//Remove removes the attachment from the vxlan and returns the new count
+func (rc *RefCount) Remove(a Attachment) (int, error) {
+	id := refKey(a)
+	ids, err := rc.read()
+	if err != nil {
+		return 0, err
+	}
+
+	var remaining []string
+	for _, i := range ids {
+		if i != id {
+			remaining = append(remaining, i)
+		}
+	}
+
+	return len(remaining), rc.write(remaining)
+}
+
+//Retain removes every attachment not in valid from the vxlan and returns the new count
+func (rc *RefCount) Retain(valid map[Attachment]bool) (int, error) {
+	ids, err := rc.read()
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make(map[string]bool)
+	for a := range valid {
+		keys[refKey(a)] = true
+	}
+
+	var remaining []string
+	for _, i := range ids {
+		if keys[i] {
+			remaining = append(remaining, i)
+		}
+	}
+
+	if len(remaining) == len(ids) {
+		return len(ids), nil
+	}
+
+	return len(remaining), rc.write(remaining)
+}
+
+//Count returns the number of attachments to the vxlan
+func (rc *RefCount) Count() (int, error) {
+	ids, err := rc.read()
+	return len(ids), err
+}
+
+func (rc *RefCount) read() ([]string, error) {
+	b, err := ioutil.ReadFile(rc.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, id := range strings.Split(string(b), "\n") {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (rc *RefCount) write(ids []string) error {
+	if len(ids) == 0 {
+		err := os.Remove(rc.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	tmp := rc.path + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strings.Join(ids, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, rc.path)
+}
@@ -0,0 +1,50 @@
+package vxlan
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRefCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vxlan-refcount")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rc := NewRefCount(dir, "front")
+	eth0 := Attachment{ContainerID: "abc", IfName: "eth0"}
+	net1 := Attachment{ContainerID: "abc", IfName: "net1"}
+	other := Attachment{ContainerID: "def", IfName: "eth0"}
+
+	steps := []struct {
+		name string
+		do   func() (int, error)
+		want int
+	}{
+		{"add", func() (int, error) { return rc.Add(eth0) }, 1},
+		{"add again", func() (int, error) { return rc.Add(eth0) }, 1},
+		{"same container on another interface", func() (int, error) { return rc.Add(net1) }, 2},
+		{"another container", func() (int, error) { return rc.Add(other) }, 3},
+		{"remove one interface", func() (int, error) { return rc.Remove(eth0) }, 2},
+		{"remove it again", func() (int, error) { return rc.Remove(eth0) }, 2},
+		{"retain valid", func() (int, error) { return rc.Retain(map[Attachment]bool{net1: true, eth0: true}) }, 1},
+		{"count", rc.Count, 1},
+		{"remove the last", func() (int, error) { return rc.Remove(net1) }, 0},
+	}
+
+	for _, s := range steps {
+		got, err := s.do()
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", s.name, err)
+		}
+		if got != s.want {
+			t.Fatalf("%v: expected count %v, got %v", s.name, s.want, got)
+		}
+	}
+
+	if _, err := os.Stat(rc.path); !os.IsNotExist(err) {
+		t.Errorf("expected the reference count file to be removed with the last attachment, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package vxlan
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var zeroMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+
+//GetRemotes returns the remote VTEP addresses configured for the vxlan, from both Remotes and RemotesPath
+//RemotesPath may be a file with one address per line, or a directory of such files
+func (v *Vxlan) GetRemotes() ([]net.IP, error) {
+	var remotes []net.IP
+	for _, r := range v.Remotes {
+		ip := net.ParseIP(r)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid remote vtep address %v", r)
+		}
+		remotes = append(remotes, ip)
+	}
+
+	if v.RemotesPath == "" {
+		return remotes, nil
+	}
+
+	fi, err := os.Stat(v.RemotesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{v.RemotesPath}
+	if fi.IsDir() {
+		infos, err := ioutil.ReadDir(v.RemotesPath)
+		if err != nil {
+			return nil, err
+		}
+
+		files = nil
+		for _, i := range infos {
+			if i.Mode().IsRegular() {
+				files = append(files, filepath.Join(v.RemotesPath, i.Name()))
+			}
+		}
+	}
+
+	for _, f := range files {
+		ips, err := readRemotesFile(f)
+		if err != nil {
+			return nil, err
+		}
+		remotes = append(remotes, ips...)
+	}
+
+	return remotes, nil
+}
+
+func readRemotesFile(path string) ([]net.IP, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var remotes []net.IP
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+
+		ip := net.ParseIP(line)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid remote vtep address %v in %v", line, path)
+		}
+		remotes = append(remotes, ip)
+	}
+
+	return remotes, scanner.Err()
+}
+
+//ReconcileRemotes programs an all-zero mac fdb entry on the vxlan interface for every configured remote vtep
+//so broadcast, unknown unicast and multicast traffic is replicated to them without relying on underlay multicast.
+//The entries it appends are recorded, and only those are removed once their remotes are no longer configured,
+//so flood entries installed by vxlan-evpn or by hand are left alone, even for configured remotes. Nothing is touched if no remotes are configured.
+func (hi *HostInterface) ReconcileRemotes() error {
+	if len(hi.VxlanParams.Remotes) == 0 && hi.VxlanParams.RemotesPath == "" {
+		return nil
+	}
+
+	log.Debugf("HostInterface.ReconcileRemotes()")
+	remotes, err := hi.VxlanParams.GetRemotes()
+	if err != nil {
+		return err
+	}
+
+	local, err := localAddresses()
+	if err != nil {
+		return err
+	}
+
+	want := make(map[string]net.IP)
+	for _, r := range remotes {
+		if local[r.String()] {
+			continue
+		}
+		want[r.String()] = r
+	}
+
+	added, err := readAddedRemotes(hi.VxlanParams.addedRemotesPath())
+	if err != nil {
+		return err
+	}
+
+	neighs, err := netlink.NeighList(hi.vxLink.Attrs().Index, unix.AF_BRIDGE)
+	if err != nil {
+		return err
+	}
+
+	have := make(map[string]bool)
+	for _, n := range neighs {
+		if n.IP == nil || n.HardwareAddr.String() != zeroMAC.String() {
+			continue
+		}
+
+		if _, ok := want[n.IP.String()]; ok {
+			have[n.IP.String()] = true
+			continue
+		}
+
+		if !added[n.IP.String()] {
+			continue
+		}
+
+		log.WithField("remote", n.IP).Debugf("removing stale flood entry")
+		err = netlink.NeighDel(hi.floodEntry(n.IP))
+		if err != nil {
+			return err
+		}
+	}
+
+	//only entries this reconciler appended are recorded, one that was already there belongs to whoever added it
+	ours := make(map[string]net.IP)
+	for k, ip := range want {
+		if have[k] {
+			if added[k] {
+				ours[k] = ip
+			}
+			continue
+		}
+
+		log.WithField("remote", ip).Debugf("adding flood entry")
+		err = netlink.NeighAppend(hi.floodEntry(ip))
+		if err != nil {
+			break
+		}
+		ours[k] = ip
+	}
+
+	werr := writeAddedRemotes(hi.VxlanParams.addedRemotesPath(), ours)
+	if err != nil {
+		return err
+	}
+	return werr
+}
+
+//addedRemotesPath is the file recording the flood entries ReconcileRemotes added to the vxlan's interface
+func (v *Vxlan) addedRemotesPath() string {
+	dir := v.lockDir
+	if dir == "" {
+		dir = DefaultLockPath
+	}
+	return dir + string(os.PathSeparator) + "vxlan-" + v.Name + DefaultRemotesExt
+}
+
+func readAddedRemotes(path string) (map[string]bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	added := make(map[string]bool)
+	for _, r := range strings.Split(string(b), "\n") {
+		if r != "" {
+			added[r] = true
+		}
+	}
+
+	return added, nil
+}
+
+func writeAddedRemotes(path string, remotes map[string]net.IP) error {
+	if len(remotes) == 0 {
+		return removeAddedRemotes(path)
+	}
+
+	var lines []string
+	for k := range remotes {
+		lines = append(lines, k)
+	}
+	sort.Strings(lines)
+
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func removeAddedRemotes(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (hi *HostInterface) floodEntry(remote net.IP) *netlink.Neigh {
+	return &netlink.Neigh{
+		LinkIndex:    hi.vxLink.Attrs().Index,
+		Family:       unix.AF_BRIDGE,
+		State:        netlink.NUD_PERMANENT,
+		Flags:        netlink.NTF_SELF,
+		IP:           remote,
+		HardwareAddr: zeroMAC,
+	}
+}
+
+func localAddresses() (map[string]bool, error) {
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	local := make(map[string]bool)
+	for _, a := range addrs {
+		local[a.IP.String()] = true
+	}
+
+	return local, nil
+}
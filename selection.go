@@ -0,0 +1,190 @@
+package vxlan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+//NetworkSelection is one of the networks a pod is attached to, as listed in the NetworkAnnotation or MultusNetworksAnnotation
+//Addresses are requested from the network's ipam, one per family, and Routes are sent through the network's gateway
+//Namespace is only set for a selection read from the MultusNetworksAnnotation, where it qualifies the name in the network status
+type NetworkSelection struct {
+	Name         string   `json:"name"`
+	Namespace    string   `json:"namespace,omitempty"`
+	Interface    string   `json:"interface"`
+	Addresses    []string `json:"addresses"`
+	MAC          string   `json:"mac"`
+	DefaultRoute *bool    `json:"defaultRoute"`
+	Routes       []string `json:"routes"`
+}
+
+//ParseNetworkSelections parses the value of a NetworkAnnotation, either a network name or a json list of NetworkSelections
+//the first network is attached on ifname and carries the default route unless told otherwise,
+//the rest are attached on the first of net1, net2 and so on not otherwise used, and carry only their own routes
+func ParseNetworkSelections(s, ifname string) ([]*NetworkSelection, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		dr := true
+		return []*NetworkSelection{{Name: s, Interface: ifname, DefaultRoute: &dr}}, nil
+	}
+
+	var sels []*NetworkSelection
+	err := json.Unmarshal([]byte(s), &sels)
+	if err != nil {
+		return nil, err
+	}
+	return resolveSelections(sels, ifname)
+}
+
+//resolveSelections fills in the interfaces and default routes left unset, and validates the selections
+func resolveSelections(sels []*NetworkSelection, ifname string) ([]*NetworkSelection, error) {
+	if len(sels) == 0 {
+		return nil, fmt.Errorf("no networks listed")
+	}
+
+	//interfaces named explicitly are taken before any are picked for the selections that leave it unset
+	taken := make(map[string]bool)
+	for i, sel := range sels {
+		if sel == nil || sel.Name == "" {
+			return nil, fmt.Errorf("network %v has no name", i)
+		}
+		if sel.Interface != "" {
+			taken[sel.Interface] = true
+		}
+	}
+
+	names := make(map[string]bool)
+	ifnames := make(map[string]bool)
+	defaults := 0
+	next := 1
+	for i, sel := range sels {
+		if names[sel.Name] {
+			return nil, fmt.Errorf("network %v is listed more than once", sel.Name)
+		}
+		names[sel.Name] = true
+
+		if sel.Interface == "" {
+			if i == 0 && !taken[ifname] {
+				sel.Interface = ifname
+			} else {
+				//the first free netN, never ifname, which the first network or the runtime may still use
+				for sel.Interface == "" || sel.Interface == ifname || taken[sel.Interface] {
+					sel.Interface = fmt.Sprintf("net%v", next)
+					next++
+				}
+			}
+			taken[sel.Interface] = true
+		}
+		if len(sel.Interface) > MaxInterfaceName {
+			return nil, fmt.Errorf("network %v: interface %v is longer than %v characters", sel.Name, sel.Interface, MaxInterfaceName)
+		}
+		if ifnames[sel.Interface] {
+			return nil, fmt.Errorf("network %v: interface %v is already used by another network", sel.Name, sel.Interface)
+		}
+		ifnames[sel.Interface] = true
+
+		for _, a := range sel.Addresses {
+			if net.ParseIP(a) == nil {
+				return nil, fmt.Errorf("network %v: %v is not an address", sel.Name, a)
+			}
+		}
+
+		if sel.MAC != "" {
+			err := ValidateMAC(sel.MAC, false)
+			if err != nil {
+				return nil, fmt.Errorf("network %v: %v", sel.Name, err)
+			}
+		}
+
+		for _, r := range sel.Routes {
+			if _, _, err := net.ParseCIDR(r); err != nil {
+				return nil, fmt.Errorf("network %v: %v", sel.Name, err)
+			}
+		}
+
+		if sel.DefaultRoute == nil {
+			dr := i == 0
+			sel.DefaultRoute = &dr
+		}
+		if sel.HasDefaultRoute() {
+			defaults++
+		}
+	}
+
+	if defaults > 1 {
+		return nil, fmt.Errorf("%v networks carry the default route, at most one may", defaults)
+	}
+
+	return sels, nil
+}
+
+//HasDefaultRoute reports whether the selection carries the default route
+func (s *NetworkSelection) HasDefaultRoute() bool {
+	return s.DefaultRoute != nil && *s.DefaultRoute
+}
+
+//ValidateMAC checks that s is a mac address an interface can be given, and that it is locally administered if local is set
+func ValidateMAC(s string, local bool) error {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return err
+	}
+	if len(mac) != 6 {
+		return fmt.Errorf("mac %v is not an ethernet address", s)
+	}
+	if mac[0]&1 == 1 {
+		return fmt.Errorf("mac %v is a multicast address", s)
+	}
+	if local && mac[0]&2 == 0 {
+		return fmt.Errorf("mac %v is not locally administered", s)
+	}
+	return nil
+}
+
+//HardwareAddr returns the selection's requested mac address, or nil if there is none
+func (s *NetworkSelection) HardwareAddr() net.HardwareAddr {
+	mac, _ := net.ParseMAC(s.MAC)
+	return mac
+}
+
+//StatusName returns the name of the selection's network as reported in the network status
+func (s *NetworkSelection) StatusName() string {
+	if s.Namespace != "" {
+		return s.Namespace + "/" + s.Name
+	}
+	return s.Name
+}
+
+//RequestedAddresses returns the selection's requested addresses
+func (s *NetworkSelection) RequestedAddresses() []net.IP {
+	var ips []net.IP
+	for _, a := range s.Addresses {
+		if ip := net.ParseIP(strings.TrimSpace(a)); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+//RouteDestinations returns the selection's routes
+func (s *NetworkSelection) RouteDestinations() []*net.IPNet {
+	var dsts []*net.IPNet
+	for _, r := range s.Routes {
+		if _, dst, err := net.ParseCIDR(r); err == nil {
+			dsts = append(dsts, dst)
+		}
+	}
+	return dsts
+}
+
+//GetVxlan returns the configured network with the name, or nil if there is none
+func (c *Config) GetVxlan(name string) *Vxlan {
+	for _, v := range c.Vxlans {
+		if v != nil && v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
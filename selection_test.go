@@ -0,0 +1,94 @@
+package vxlan
+
+import (
+	"reflect"
+	"testing"
+)
+
+//selectionSummary is the name, interface and default route of each selection, what resolving them fills in
+func selectionSummary(sels []*NetworkSelection) [][3]interface{} {
+	var sum [][3]interface{}
+	for _, s := range sels {
+		sum = append(sum, [3]interface{}{s.Name, s.Interface, s.HasDefaultRoute()})
+	}
+	return sum
+}
+
+func TestParseNetworkSelections(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      string
+		ifname string
+		want   [][3]interface{}
+		err    bool
+	}{
+		{name: "network name", s: " front ", ifname: "eth0", want: [][3]interface{}{{"front", "eth0", true}}},
+		{name: "list", s: `[{"name": "front"}, {"name": "back"}, {"name": "db"}]`, ifname: "eth0",
+			want: [][3]interface{}{{"front", "eth0", true}, {"back", "net1", false}, {"db", "net2", false}}},
+		{name: "defaults skip ifname", s: `[{"name": "front"}, {"name": "back"}]`, ifname: "net1",
+			want: [][3]interface{}{{"front", "net1", true}, {"back", "net2", false}}},
+		{name: "defaults skip named interfaces", s: `[{"name": "front"}, {"name": "back"}, {"name": "db", "interface": "net1"}]`, ifname: "eth0",
+			want: [][3]interface{}{{"front", "eth0", true}, {"back", "net2", false}, {"db", "net1", false}}},
+		{name: "ifname named by a later network", s: `[{"name": "front"}, {"name": "back", "interface": "eth0"}]`, ifname: "eth0",
+			want: [][3]interface{}{{"front", "net1", true}, {"back", "eth0", false}}},
+		{name: "default route moved", s: `[{"name": "front", "defaultRoute": false}, {"name": "back", "defaultRoute": true}]`, ifname: "eth0",
+			want: [][3]interface{}{{"front", "eth0", false}, {"back", "net1", true}}},
+		{name: "not json", s: `[front]`, ifname: "eth0", err: true},
+		{name: "empty list", s: `[]`, ifname: "eth0", err: true},
+		{name: "no name", s: `[{"interface": "net1"}]`, ifname: "eth0", err: true},
+		{name: "listed twice", s: `[{"name": "front"}, {"name": "front"}]`, ifname: "eth0", err: true},
+		{name: "interface used twice", s: `[{"name": "front", "interface": "net1"}, {"name": "back", "interface": "net1"}]`, ifname: "eth0", err: true},
+		{name: "interface too long", s: `[{"name": "front", "interface": "averyveryverylongname"}]`, ifname: "eth0", err: true},
+		{name: "invalid address", s: `[{"name": "front", "addresses": ["10.1.0"]}]`, ifname: "eth0", err: true},
+		{name: "invalid mac", s: `[{"name": "front", "mac": "01:00:5e:00:00:01"}]`, ifname: "eth0", err: true},
+		{name: "invalid route", s: `[{"name": "front", "routes": ["10.3.0.0"]}]`, ifname: "eth0", err: true},
+		{name: "two default routes", s: `[{"name": "front"}, {"name": "back", "defaultRoute": true}]`, ifname: "eth0", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sels, err := ParseNetworkSelections(tt.s, tt.ifname)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %v", selectionSummary(sels))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := selectionSummary(sels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateMAC(t *testing.T) {
+	tests := []struct {
+		mac   string
+		local bool
+		err   bool
+	}{
+		{mac: "02:00:00:00:00:01"},
+		{mac: "02:00:00:00:00:01", local: true},
+		{mac: "00:16:3e:00:00:01"},
+		{mac: "00:16:3e:00:00:01", local: true, err: true},
+		{mac: "01:00:5e:00:00:01", err: true},
+		{mac: "03:00:00:00:00:01", local: true, err: true},
+		{mac: "ff:ff:ff:ff:ff:ff", err: true},
+		{mac: "02-00-00-00-00-01"},
+		{mac: "0200.0000.0001"},
+		{mac: "02:00:00:00:00:00:00:01", err: true},
+		{mac: "02:00:00:00:00", err: true},
+		{mac: "not a mac", err: true},
+		{mac: "", err: true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateMAC(tt.mac, tt.local)
+		if (err != nil) != tt.err {
+			t.Errorf("ValidateMAC(%q, %v) = %v, expected error %v", tt.mac, tt.local, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package vxlan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cni "github.com/phdata/go-libcni"
+)
+
+//AttachmentState is the record of a container interface attached by ADD
+type AttachmentState struct {
+	ContainerID string     `json:"containerID"`
+	Netns       string     `json:"netns"`
+	IfName      string     `json:"ifName"`
+	Network     string     `json:"network"`
+	VNI         int        `json:"vni"`
+	Addresses   []string   `json:"addresses"`
+	MAC         string     `json:"mac,omitempty"`
+	DNS         *cni.DNS   `json:"dns,omitempty"`
+	Bandwidth   *Bandwidth `json:"bandwidth,omitempty"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+}
+
+//IPNets returns the attachment's addresses, skipping any that fail to parse
+func (a *AttachmentState) IPNets() []*net.IPNet {
+	var addrs []*net.IPNet
+	for _, s := range a.Addresses {
+		ip, n, err := net.ParseCIDR(s)
+		if err != nil {
+			continue
+		}
+		n.IP = ip
+		addrs = append(addrs, n)
+	}
+	return addrs
+}
+
+//IPs returns the attachment's addresses as ipam results, for releasing them
+func (a *AttachmentState) IPs() []*cni.IP {
+	var ips []*cni.IP
+	for _, addr := range a.IPNets() {
+		ips = append(ips, &cni.IP{Version: IPVersion(addr.IP), Address: addr.String()})
+	}
+	return ips
+}
+
+//StateStore keeps a record of every attachment on this host, one file per container interface
+//records for different containers may be written concurrently, a single record should only be written while holding its network's Lock
+type StateStore struct {
+	path string
+}
+
+//NewStateStore returns a StateStore under the lock directory dir, or under DefaultLockPath if dir is empty
+func NewStateStore(dir string) *StateStore {
+	if dir == "" {
+		dir = DefaultLockPath
+	}
+	return &StateStore{path: dir + string(os.PathSeparator) + DefaultStateDir}
+}
+
+func (s *StateStore) file(containerID, ifname string) string {
+	return filepath.Join(s.path, containerID+"_"+ifname+DefaultRecordExt)
+}
+
+//Get returns the record of the container's interface, or nil if there is none
+func (s *StateStore) Get(containerID, ifname string) (*AttachmentState, error) {
+	b, err := ioutil.ReadFile(s.file(containerID, ifname))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := &AttachmentState{}
+	err = json.Unmarshal(b, a)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+//Save writes the record atomically, keeping the creation time of any record it replaces
+func (s *StateStore) Save(a *AttachmentState) error {
+	err := os.MkdirAll(s.path, 0700)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	a.Updated = now
+	if a.Created.IsZero() {
+		a.Created = now
+		if old, err := s.Get(a.ContainerID, a.IfName); err == nil && old != nil {
+			a.Created = old.Created
+		}
+	}
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.path, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.file(a.ContainerID, a.IfName))
+}
+
+//Delete removes the record of the container's interface, it is not an error if there is none
+func (s *StateStore) Delete(containerID, ifname string) error {
+	err := os.Remove(s.file(containerID, ifname))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//List returns every record in the store, unreadable records are skipped
+func (s *StateStore) List() ([]*AttachmentState, error) {
+	files, err := ioutil.ReadDir(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var states []*AttachmentState
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), DefaultRecordExt) {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(s.path, f.Name()))
+		if err != nil {
+			continue
+		}
+
+		a := &AttachmentState{}
+		if json.Unmarshal(b, a) != nil {
+			continue
+		}
+		states = append(states, a)
+	}
+
+	return states, nil
+}
+
+//ListNetwork returns the records of the containers attached to the named network
+func (s *StateStore) ListNetwork(network string) ([]*AttachmentState, error) {
+	states, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*AttachmentState
+	for _, a := range states {
+		if a.Network == network {
+			matched = append(matched, a)
+		}
+	}
+	return matched, nil
+}
+
+//ListContainer returns the records of the container's attachments, one per interface
+func (s *StateStore) ListContainer(containerID string) ([]*AttachmentState, error) {
+	states, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*AttachmentState
+	for _, a := range states {
+		if a.ContainerID == containerID {
+			matched = append(matched, a)
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,55 @@
+package vxlan
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+
+	"github.com/vishvananda/netlink"
+)
+
+//Status checks that the host is able to attach containers to the vxlans in the config
+//it verifies every configured vtep device is up, the lock directory is writable, the IPAM binary is executable
+//and nft is available if a network policy is configured
+func (c *Config) Status(ipamBin string) error {
+	for _, v := range c.Vxlans {
+		vtep, ok := v.Options["vtepdev"]
+		if !ok {
+			continue
+		}
+
+		link, err := netlink.LinkByName(vtep)
+		if err != nil {
+			return fmt.Errorf("vtep device %v for network %v not found: %v", vtep, v.Name, err)
+		}
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			return fmt.Errorf("vtep device %v for network %v is down", vtep, v.Name)
+		}
+	}
+
+	f, err := ioutil.TempFile(c.GetLockDir(), "vxlan-status-")
+	if err != nil {
+		return fmt.Errorf("lock directory %v is not writable: %v", c.GetLockDir(), err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	fi, err := os.Stat(ipamBin)
+	if err != nil {
+		return fmt.Errorf("ipam plugin %v not found: %v", ipamBin, err)
+	}
+	if fi.IsDir() || fi.Mode()&0111 == 0 {
+		return fmt.Errorf("ipam plugin %v is not executable", ipamBin)
+	}
+
+	if c.Policy != nil {
+		_, err = exec.LookPath(DefaultNftBin)
+		if err != nil {
+			return fmt.Errorf("a network policy is configured, but %v is not available: %v", DefaultNftBin, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+package vxlan
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TrilliumIT/iputil"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+//vxlanOptions maps every option understood by createVxlanLink to a function validating its value
+var vxlanOptions = map[string]func(string) error{
+	"vxlanhardwareaddr": validateMAC,
+	"vxlantxqlen":       validateInt,
+	"vtepdev":           validateString,
+	"srcaddr":           validateIP,
+	"group":             validateIP,
+	"ttl":               validateInt,
+	"tos":               validateInt,
+	"learning":          validateBool,
+	"proxy":             validateBool,
+	"rsc":               validateBool,
+	"l2miss":            validateBool,
+	"l3miss":            validateBool,
+	"noage":             validateBool,
+	"gbp":               validateBool,
+	"age":               validateInt,
+	"limit":             validateInt,
+	"port":              validateInt,
+	"portlow":           validateInt,
+	"porthigh":          validateInt,
+}
+
+//ValidationError holds every problem found in a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+func (e *ValidationError) add(format string, a ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, a...))
+}
+
+//Validate checks the config for problems that would otherwise surface as failures or panics during ADD
+//all problems are returned at once as a *ValidationError
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+
+	names := make(map[string]bool)
+	ids := make(map[int]string)
+	var nets []*net.IPNet
+	var netNames []string
+	//route table to the vrf using it, "" for networks not in a vrf
+	tables := make(map[int]string)
+
+	for i, v := range c.Vxlans {
+		if v == nil {
+			verr.add("vxlans[%v] is empty", i)
+			continue
+		}
+
+		name := v.Name
+		if name == "" {
+			verr.add("vxlans[%v] has no name", i)
+			name = fmt.Sprintf("vxlans[%v]", i)
+		} else if names[name] {
+			verr.add("duplicate network name %v", name)
+		}
+		names[name] = true
+
+		if len("vx_"+v.Name) > MaxInterfaceName {
+			verr.add("network %v: name is too long, vx_%v must be at most %v characters", name, v.Name, MaxInterfaceName)
+		}
+
+		if v.ID < 1 || v.ID > MaxVNI {
+			verr.add("network %v: id %v is outside the VNI range 1-%v", name, v.ID, MaxVNI)
+		} else if other, ok := ids[v.ID]; ok {
+			verr.add("network %v: id %v is already used by network %v", name, v.ID, other)
+		} else {
+			ids[v.ID] = name
+		}
+
+		if v.ExcludeFirst < 0 || v.ExcludeLast < 0 {
+			verr.add("network %v: excludeFirst and excludeLast must not be negative", name)
+		}
+
+		//0 derives the mtu from the vtep device, whether a set mtu fits the vtep device is checked when the network is brought up
+		minMTU := MinMTU
+		for _, cidr := range v.GetCidrs() {
+			if ip, _, err := net.ParseCIDR(cidr); err == nil && ip.To4() == nil {
+				minMTU = MinMTUV6
+			}
+		}
+		if v.MTU != 0 && (v.MTU < minMTU || v.MTU > MaxMTU) {
+			verr.add("network %v: mtu %v is outside %v-%v", name, v.MTU, minMTU, MaxMTU)
+		}
+
+		//0 is unspecified, the others are the kernel's default, main and local tables
+		if v.RouteTable < 0 || (v.RouteTable >= 253 && v.RouteTable <= 255) {
+			verr.add("network %v: routeTable %v is reserved or invalid", name, v.RouteTable)
+		}
+
+		if v.RulePriority < 0 {
+			verr.add("network %v: rulePriority must not be negative", name)
+		}
+
+		if msg := v.Bandwidth.validate(); msg != "" {
+			verr.add("network %v: bandwidth %v", name, msg)
+		}
+
+		if v.VxlanEgressBurst > 0 && v.VxlanEgressRate == 0 {
+			verr.add("network %v: vxlanEgressBurst requires vxlanEgressRate", name)
+		}
+
+		if len(v.VRF) > MaxInterfaceName {
+			verr.add("network %v: vrf name %v is longer than %v characters", name, v.VRF, MaxInterfaceName)
+		}
+
+		//a vrf's table holds only its own networks' routes, so it can't be shared with another vrf or the bypass routes of networks outside one
+		if v.VRF != "" || v.HasBypassRoute() {
+			table := v.GetRouteTable()
+			if owner, ok := tables[table]; ok && owner != v.VRF {
+				verr.add("network %v: routeTable %v is already used by %v", name, table, tableUser(owner))
+			} else {
+				tables[table] = v.VRF
+			}
+		}
+
+		cidrs := v.GetCidrs()
+		if len(cidrs) == 0 {
+			verr.add("network %v: no cidr configured", name)
+		}
+
+		for _, cidr := range cidrs {
+			ipnet, err := netlink.ParseIPNet(cidr)
+			if err != nil {
+				verr.add("network %v: invalid cidr %v: %v", name, cidr, err)
+				continue
+			}
+
+			if ipnet.IP.Equal(iputil.NetworkID(ipnet).IP) {
+				verr.add("network %v: cidr %v must be the gateway address, not the network address", name, cidr)
+			}
+
+			ones, bits := ipnet.Mask.Size()
+			if hostBits := bits - ones; hostBits < 31 && v.ExcludeFirst+v.ExcludeLast+2 >= 1<<uint(hostBits) {
+				verr.add("network %v: excludeFirst and excludeLast leave no usable addresses in %v", name, cidr)
+			}
+
+			for j, other := range nets {
+				if iputil.SubnetEqualSubnet(ipnet, other) {
+					verr.add("network %v: cidr %v duplicates network %v", name, cidr, netNames[j])
+				} else if iputil.SubnetContainsSubnet(ipnet, other) || iputil.SubnetContainsSubnet(other, ipnet) {
+					verr.add("network %v: cidr %v overlaps network %v", name, cidr, netNames[j])
+				}
+			}
+			nets = append(nets, ipnet)
+			netNames = append(netNames, name)
+		}
+
+		for _, r := range v.Remotes {
+			if net.ParseIP(r) == nil {
+				verr.add("network %v: invalid remote vtep address %v", name, r)
+			}
+		}
+
+		if v.RemotesPath != "" {
+			if _, err := os.Stat(v.RemotesPath); err != nil {
+				verr.add("network %v: remotesPath: %v", name, err)
+			}
+		}
+
+		keys := make([]string, 0, len(v.Options))
+		for k := range v.Options {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			val := v.Options[k]
+			validate, ok := vxlanOptions[k]
+			if !ok {
+				verr.add("network %v: unknown option %v", name, k)
+				continue
+			}
+
+			err := validate(val)
+			if err != nil {
+				verr.add("network %v: invalid value %q for option %v: %v", name, val, k, err)
+			}
+		}
+	}
+
+	if c.LogLevel != "" {
+		if _, err := log.ParseLevel(c.LogLevel); err != nil {
+			verr.add("logLevel: %v", err)
+		}
+	}
+
+	if c.LogFormat != "" && c.LogFormat != "text" && c.LogFormat != "json" {
+		verr.add("logFormat %v must be text or json", c.LogFormat)
+	}
+
+	if c.LogSink != "" && c.LogSink != "syslog" && c.LogSink != "journald" {
+		verr.add("logSink %v must be syslog or journald", c.LogSink)
+	}
+
+	if c.LogMaxSize < 0 || c.LogMaxBackups < 0 {
+		verr.add("logMaxSize and logMaxBackups must not be negative")
+	}
+
+	if c.LockDir != "" && !filepath.IsAbs(c.LockDir) {
+		verr.add("lockDir %v must be an absolute path", c.LockDir)
+	}
+
+	if c.Timeout < 0 {
+		verr.add("timeout must not be negative")
+	}
+
+	if c.Policy != nil {
+		if c.Policy.Default != "" && c.Policy.Default != "allow" && c.Policy.Default != "deny" {
+			verr.add("policy default %v must be allow or deny", c.Policy.Default)
+		}
+
+		for i, r := range c.Policy.Rules {
+			if r == nil {
+				verr.add("policy rules[%v] is empty", i)
+				continue
+			}
+			if !names[r.From] {
+				verr.add("policy rules[%v]: from %v is not a configured network", i, r.From)
+			}
+			if !names[r.To] {
+				verr.add("policy rules[%v]: to %v is not a configured network", i, r.To)
+			}
+			if r.From == r.To {
+				verr.add("policy rules[%v]: from and to are both %v", i, r.From)
+			}
+			if r.Action != "" && r.Action != "allow" && r.Action != "deny" {
+				verr.add("policy rules[%v]: action %v must be allow or deny", i, r.Action)
+			}
+			for _, p := range r.Ports {
+				if _, _, err := parsePort(p); err != nil {
+					verr.add("policy rules[%v]: %v", i, err)
+				}
+			}
+		}
+	}
+
+	if c.RuntimeConfig != nil {
+		if msg := c.RuntimeConfig.Bandwidth.validate(); msg != "" {
+			verr.add("runtimeConfig bandwidth %v", msg)
+		}
+		if c.RuntimeConfig.MAC != "" {
+			if err := ValidateMAC(c.RuntimeConfig.MAC, c.RequireLocalMAC); err != nil {
+				verr.add("runtimeConfig %v", err)
+			}
+		}
+	}
+
+	if c.DefaultNetwork != "" && !names[c.DefaultNetwork] {
+		verr.add("defaultNetwork %v is not a configured network", c.DefaultNetwork)
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+
+	return nil
+}
+
+func tableUser(vrf string) string {
+	if vrf == "" {
+		return "networks outside a vrf"
+	}
+	return "vrf " + vrf
+}
+
+func validateMAC(s string) error {
+	_, err := net.ParseMAC(s)
+	return err
+}
+
+func validateInt(s string) error {
+	_, err := strconv.Atoi(s)
+	return err
+}
+
+func validateBool(s string) error {
+	_, err := strconv.ParseBool(s)
+	return err
+}
+
+func validateIP(s string) error {
+	if net.ParseIP(s) == nil {
+		return fmt.Errorf("not an ip address")
+	}
+	return nil
+}
+
+func validateString(s string) error {
+	if s == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package vxlan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     string
+		problems []string
+	}{
+		{
+			name: "valid",
+			conf: `{"vxlans": [{"name": "a", "id": 1, "cidr": "10.1.0.1/16"}, {"name": "b", "id": 2, "cidrs": ["10.2.0.1/16", "fd00::1/64"]}], "defaultNetwork": "a"}`,
+		},
+		{
+			name:     "no networks problems",
+			conf:     `{"vxlans": [null, {"id": 1, "cidr": "10.1.0.1/16"}]}`,
+			problems: []string{"vxlans[0] is empty", "vxlans[1] has no name"},
+		},
+		{
+			name:     "duplicate name and id",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "10.1.0.1/16"}, {"name": "a", "id": 1, "cidr": "10.2.0.1/16"}]}`,
+			problems: []string{"duplicate network name a", "network a: id 1 is already used by network a"},
+		},
+		{
+			name:     "vni out of range",
+			conf:     `{"vxlans": [{"name": "a", "id": 16777216, "cidr": "10.1.0.1/16"}]}`,
+			problems: []string{"id 16777216 is outside the VNI range"},
+		},
+		{
+			name:     "name too long",
+			conf:     `{"vxlans": [{"name": "averyverylongname", "id": 1, "cidr": "10.1.0.1/16"}]}`,
+			problems: []string{"name is too long"},
+		},
+		{
+			name:     "network address as cidr",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "10.1.0.0/16"}]}`,
+			problems: []string{"must be the gateway address"},
+		},
+		{
+			name:     "invalid and missing cidr",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "bogus"}, {"name": "b", "id": 2}]}`,
+			problems: []string{"network a: invalid cidr bogus", "network b: no cidr configured"},
+		},
+		{
+			name:     "overlapping cidrs",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "10.1.0.1/16"}, {"name": "b", "id": 2, "cidr": "10.1.2.1/24"}, {"name": "c", "id": 3, "cidr": "10.1.0.2/16"}]}`,
+			problems: []string{"network b: cidr 10.1.2.1/24 overlaps network a", "network c: cidr 10.1.0.2/16 duplicates network a", "network c: cidr 10.1.0.2/16 overlaps network b"},
+		},
+		{
+			name:     "excludes leave no addresses",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "10.1.0.1/30", "excludeFirst": 1, "excludeLast": 1}]}`,
+			problems: []string{"leave no usable addresses"},
+		},
+		{
+			name:     "bad options",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "10.1.0.1/16", "options": {"ttl": "x", "bogus": "1", "srcaddr": "10.0.0.1"}}]}`,
+			problems: []string{"unknown option bogus", `invalid value "x" for option ttl`},
+		},
+		{
+			name:     "invalid remote",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "10.1.0.1/16", "remotes": ["10.0.0.2", "nope"]}]}`,
+			problems: []string{"invalid remote vtep address nope"},
+		},
+		{
+			name:     "mtu out of range",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "fd00::1/64", "mtu": 1000}]}`,
+			problems: []string{"mtu 1000 is outside"},
+		},
+		{
+			name:     "top level settings",
+			conf:     `{"vxlans": [{"name": "a", "id": 1, "cidr": "10.1.0.1/16"}], "logLevel": "loud", "logFormat": "xml", "lockDir": "relative", "timeout": -1, "defaultNetwork": "b"}`,
+			problems: []string{"logLevel", "logFormat xml", "lockDir relative must be an absolute path", "timeout must not be negative", "defaultNetwork b is not a configured network"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := NewConfig([]byte(tt.conf))
+			if err != nil {
+				t.Fatalf("failed to parse config: %v", err)
+			}
+
+			err = conf.Validate()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("expected a *ValidationError, got %v", err)
+			}
+			if len(verr.Problems) != len(tt.problems) {
+				t.Errorf("expected %v problems, got %v: %v", len(tt.problems), len(verr.Problems), verr)
+			}
+			for _, want := range tt.problems {
+				if !strings.Contains(verr.Error(), want) {
+					t.Errorf("expected a problem containing %q, got %v", want, verr)
+				}
+			}
+		})
+	}
+}
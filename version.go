@@ -0,0 +1,113 @@
+package vxlan
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	cni "github.com/phdata/go-libcni"
+)
+
+//SupportedVersions are the CNI spec versions this plugin can negotiate with the runtime
+var SupportedVersions = []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0", "1.1.0"}
+
+//VersionInfo is the output of the VERSION command
+type VersionInfo struct {
+	CNIVersion        string   `json:"cniVersion"`
+	SupportedVersions []string `json:"supportedVersions"`
+}
+
+//IsSupportedVersion returns true if version is one of the SupportedVersions
+func IsSupportedVersion(version string) bool {
+	for _, v := range SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+//VersionAtLeast returns true if version is greater than or equal to min
+//unparsable components are treated as 0
+func VersionAtLeast(version, min string) bool {
+	va := strings.Split(version, ".")
+	ma := strings.Split(min, ".")
+	for i := 0; i < len(ma); i++ {
+		var v, m int
+		if i < len(va) {
+			v, _ = strconv.Atoi(va[i])
+		}
+		m, _ = strconv.Atoi(ma[i])
+		if v != m {
+			return v > m
+		}
+	}
+	return true
+}
+
+type versionedResult struct {
+	CNIVersion string                `json:"cniVersion"`
+	Interfaces []*versionedInterface `json:"interfaces,omitempty"`
+	IPs        []*versionedIP        `json:"ips,omitempty"`
+	Routes     []*cni.Route          `json:"routes,omitempty"`
+	DNS        *cni.DNS              `json:"dns,omitempty"`
+}
+
+type versionedInterface struct {
+	Name    string `json:"name"`
+	MAC     string `json:"mac,omitempty"`
+	MTU     int    `json:"mtu,omitempty"`
+	Sandbox string `json:"sandbox,omitempty"`
+}
+
+type versionedIP struct {
+	Version   string `json:"version,omitempty"`
+	Address   string `json:"address"`
+	Gateway   string `json:"gateway,omitempty"`
+	Interface *int   `json:"interface,omitempty"`
+}
+
+//MarshalResult marshals the result in the shape defined by the requested CNI spec version
+//IPs carry a "version" field before 1.0.0 and omit it from 1.0.0 onward
+//interfaces carry their mtu from mtus, if it isn't 0, from 1.1.0 onward
+func MarshalResult(r *cni.Result, version string, mtus []int) ([]byte, error) {
+	vr := &versionedResult{
+		CNIVersion: version,
+		Routes:     r.Routes,
+		DNS:        r.DNS,
+	}
+
+	for n, i := range r.Interfaces {
+		vi := &versionedInterface{
+			Name:    i.Name,
+			MAC:     i.MAC,
+			Sandbox: i.Sandbox,
+		}
+		if n < len(mtus) && VersionAtLeast(version, "1.1.0") {
+			vi.MTU = mtus[n]
+		}
+		vr.Interfaces = append(vr.Interfaces, vi)
+	}
+
+	for _, ip := range r.IPs {
+		vip := &versionedIP{
+			Address:   ip.Address,
+			Gateway:   ip.Gateway,
+			Interface: ip.Interface,
+		}
+
+		if !VersionAtLeast(version, "1.0.0") {
+			vip.Version = ip.Version
+			if vip.Version == "" {
+				if addr, _, err := net.ParseCIDR(ip.Address); err == nil {
+					vip.Version = IPVersion(addr)
+				}
+			}
+		}
+
+		vr.IPs = append(vr.IPs, vip)
+	}
+
+	return json.Marshal(vr)
+}
@@ -0,0 +1,106 @@
+package vxlan
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	cni "github.com/phdata/go-libcni"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version, min string
+		want         bool
+	}{
+		{"0.3.1", "0.3.0", true},
+		{"0.3.0", "0.3.1", false},
+		{"1.0.0", "0.4.0", true},
+		{"0.4.0", "1.0.0", false},
+		{"1.1.0", "1.1.0", true},
+		{"1.0", "1.0.0", true},
+		{"", "0.1.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := VersionAtLeast(tt.version, tt.min); got != tt.want {
+			t.Errorf("VersionAtLeast(%q, %q) = %v, expected %v", tt.version, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalResult(t *testing.T) {
+	index := 0
+	result := func() *cni.Result {
+		return &cni.Result{
+			Interfaces: []*cni.Interface{{Name: "eth0", MAC: "02:00:00:00:00:01", Sandbox: "/var/run/netns/test"}},
+			IPs: []*cni.IP{
+				{Address: "10.1.0.5/16", Gateway: "10.1.0.1", Interface: &index},
+				{Version: "6", Address: "fd00::5/64", Gateway: "fd00::1", Interface: &index},
+			},
+			Routes: []*cni.Route{{Destination: "0.0.0.0/0", Gateway: "10.1.0.1"}},
+		}
+	}
+
+	tests := []struct {
+		version  string
+		ipFields []string
+		mtu      bool
+	}{
+		{"0.3.1", []string{"address", "gateway", "interface", "version"}, false},
+		{"0.4.0", []string{"address", "gateway", "interface", "version"}, false},
+		{"1.0.0", []string{"address", "gateway", "interface"}, false},
+		{"1.1.0", []string{"address", "gateway", "interface"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			b, err := MarshalResult(result(), tt.version, []int{1450})
+			if err != nil {
+				t.Fatalf("failed to marshal result: %v", err)
+			}
+
+			var out struct {
+				CNIVersion string                   `json:"cniVersion"`
+				Interfaces []map[string]interface{} `json:"interfaces"`
+				IPs        []map[string]interface{} `json:"ips"`
+				Routes     []map[string]interface{} `json:"routes"`
+			}
+			err = json.Unmarshal(b, &out)
+			if err != nil {
+				t.Fatalf("failed to unmarshal result: %v", err)
+			}
+
+			if out.CNIVersion != tt.version {
+				t.Errorf("expected cniVersion %v, got %v", tt.version, out.CNIVersion)
+			}
+			if len(out.Routes) != 1 {
+				t.Errorf("expected 1 route, got %v", out.Routes)
+			}
+
+			for _, ip := range out.IPs {
+				var fields []string
+				for _, f := range []string{"address", "gateway", "interface", "version"} {
+					if _, ok := ip[f]; ok {
+						fields = append(fields, f)
+					}
+				}
+				if !reflect.DeepEqual(fields, tt.ipFields) {
+					t.Errorf("expected ip fields %v, got %v", tt.ipFields, fields)
+				}
+			}
+			if v, ok := out.IPs[0]["version"]; ok && v != "4" {
+				t.Errorf("expected the missing ip version to be derived as 4, got %v", v)
+			}
+
+			if out.Interfaces[0]["sandbox"] != "/var/run/netns/test" {
+				t.Errorf("expected the interface's sandbox, got %v", out.Interfaces[0])
+			}
+
+			_, hasMTU := out.Interfaces[0]["mtu"]
+			if hasMTU != tt.mtu {
+				t.Errorf("expected mtu present %v, got %v", tt.mtu, out.Interfaces[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package vxlan
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+//getOrCreateVRF returns the vxlan's VRF device, creating it with the vxlan's route table if it doesn't exist
+func (hi *HostInterface) getOrCreateVRF() (netlink.Link, error) {
+	name := hi.VxlanParams.VRF
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		vrf, ok := link.(*netlink.Vrf)
+		if !ok {
+			return nil, fmt.Errorf("%v exists and is a %v, not a vrf", name, link.Type())
+		}
+		if int(vrf.Table) != hi.VxlanParams.GetRouteTable() {
+			return nil, fmt.Errorf("vrf %v uses table %v, not %v", name, vrf.Table, hi.VxlanParams.GetRouteTable())
+		}
+		return vrf, nil
+	}
+
+	log.Debugf("%v vrf nil, creating", name)
+	vrf := &netlink.Vrf{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		Table:     uint32(hi.VxlanParams.GetRouteTable()),
+	}
+
+	err = netlink.LinkAdd(vrf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = netlink.LinkSetUp(vrf)
+	if err != nil {
+		return nil, err
+	}
+
+	return netlink.LinkByName(name)
+}
+
+//enslaveVRF makes the link a slave of the vxlan's VRF, moving its connected routes into the VRF's table
+//addresses should be added afterwards, enslaving cycles the link which may drop ipv6 addresses
+func (hi *HostInterface) enslaveVRF(link netlink.Link) error {
+	vrf, err := hi.getOrCreateVRF()
+	if err != nil {
+		return err
+	}
+
+	if link.Attrs().MasterIndex == vrf.Attrs().Index {
+		return nil
+	}
+
+	log.Debugf("enslaving %v to vrf %v", link.Attrs().Name, vrf.Attrs().Name)
+	return netlink.LinkSetMasterByIndex(link, vrf.Attrs().Index)
+}
+
+//inVRF reports whether the host macvlan is enslaved to the vxlan's VRF, always true when no VRF is configured
+func (hi *HostInterface) inVRF() bool {
+	if hi.VxlanParams.VRF == "" {
+		return true
+	}
+	if hi.mvLink == nil {
+		return false
+	}
+
+	vrf, err := netlink.LinkByName(hi.VxlanParams.VRF)
+	if err != nil {
+		return false
+	}
+
+	return hi.mvLink.Attrs().MasterIndex == vrf.Attrs().Index
+}
+
+//deleteVRFIfUnused removes the vxlan's VRF once no other network's macvlan is enslaved to it
+func (hi *HostInterface) deleteVRFIfUnused() error {
+	if hi.VxlanParams.VRF == "" {
+		return nil
+	}
+
+	vrf, err := netlink.LinkByName(hi.VxlanParams.VRF)
+	if err != nil {
+		return nil
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		if l.Attrs().MasterIndex == vrf.Attrs().Index {
+			return nil
+		}
+	}
+
+	log.Debugf("deleting unused vrf %v", vrf.Attrs().Name)
+	return netlink.LinkDel(vrf)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/phdata/vxlan-cni"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	recordsPath := flag.String("records", "", "shared directory of node neighbor records, the same as the plugin's neighborRecordsPath")
+	resync := flag.Duration("resync", 30*time.Second, "how often to remove fdb and neighbor entries no longer in the records")
+	logLevel := flag.String("log-level", "info", "log level")
+	flag.Parse()
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		log.WithError(err).Fatal("invalid log level")
+	}
+	log.SetLevel(level)
+
+	if *recordsPath == "" {
+		log.Fatal("-records is required")
+	}
+
+	agent, err := vxlan.NewAgent(*recordsPath, *resync)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create agent")
+	}
+
+	done := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sigs
+		log.WithField("signal", s).Info("shutting down")
+		close(done)
+	}()
+
+	log.WithField("records", *recordsPath).Info("answering neighbor misses")
+	err = agent.Run(done)
+	if err != nil {
+		log.WithError(err).Fatal("agent failed")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/phdata/vxlan-cni/evpn"
+	log "github.com/sirupsen/logrus"
+)
+
+type peers []*evpn.Peer
+
+func (p *peers) String() string {
+	var s []string
+	for _, peer := range *p {
+		s = append(s, peer.Address+","+strconv.FormatUint(uint64(peer.ASN), 10))
+	}
+	return strings.Join(s, " ")
+}
+
+func (p *peers) Set(v string) error {
+	a := strings.Split(v, ",")
+	if len(a) != 2 {
+		return fmt.Errorf("peer must be <address[:port]>,<asn>")
+	}
+
+	asn, err := strconv.ParseUint(a[1], 10, 32)
+	if err != nil {
+		return err
+	}
+
+	*p = append(*p, &evpn.Peer{Address: a[0], ASN: uint32(asn)})
+	return nil
+}
+
+func main() {
+	var bgpPeers peers
+	asn := flag.Uint("asn", 0, "local autonomous system number")
+	routerID := flag.String("router-id", "", "bgp router id, an ipv4 address")
+	vtep := flag.String("vtep", "", "local vtep address advertised as the next hop, defaults to the router id")
+	recordsPath := flag.String("records", "", "directory of node neighbor records, the same as the plugin's neighborRecordsPath")
+	interval := flag.Duration("interval", 10*time.Second, "how often to rescan local vnis and containers")
+	logLevel := flag.String("log-level", "info", "log level")
+	flag.Var(&bgpPeers, "peer", "bgp peer as <address[:port]>,<asn>, may be repeated")
+	flag.Parse()
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		log.WithError(err).Fatal("invalid log level")
+	}
+	log.SetLevel(level)
+
+	rid := net.ParseIP(*routerID)
+	if rid == nil || rid.To4() == nil {
+		log.Fatal("-router-id must be an ipv4 address")
+	}
+
+	nh := rid
+	if *vtep != "" {
+		nh = net.ParseIP(*vtep)
+		if nh == nil {
+			log.Fatal("-vtep must be an ip address")
+		}
+	}
+
+	if *asn == 0 || len(bgpPeers) == 0 {
+		log.Fatal("-asn and at least one -peer are required")
+	}
+
+	speaker, err := evpn.NewSpeaker(uint32(*asn), rid, nh, *recordsPath, *interval, bgpPeers)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create speaker")
+	}
+
+	done := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sigs
+		log.WithField("signal", s).Info("shutting down")
+		close(done)
+	}()
+
+	log.WithFields(log.Fields{"asn": *asn, "router-id": rid, "peers": bgpPeers.String()}).Info("starting evpn speaker")
+	err = speaker.Run(done)
+	if err != nil {
+		log.WithError(err).Fatal("speaker failed")
+	}
+}
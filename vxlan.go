@@ -2,11 +2,46 @@ package vxlan
 
 // Vxlan represents the configuration for an overlay broadcast domain
 type Vxlan struct {
-	ID           int               `json:"id"`
-	Name         string            `json:"name"`
-	Cidr         string            `json:"cidr"`
-	ExcludeFirst int               `json:"excludeFirst"`
-	ExcludeLast  int               `json:"excludeLast"`
-	Options      map[string]string `json:"options"`
-	MTU          int               `json:"mtu"`
+	ID               int               `json:"id"`
+	Name             string            `json:"name"`
+	Cidr             string            `json:"cidr"`
+	Cidrs            []string          `json:"cidrs"`
+	ExcludeFirst     int               `json:"excludeFirst"`
+	ExcludeLast      int               `json:"excludeLast"`
+	Options          map[string]string `json:"options"`
+	MTU              int               `json:"mtu"`
+	Remotes          []string          `json:"remotes"`
+	RemotesPath      string            `json:"remotesPath"`
+	RouteTable       int               `json:"routeTable"`
+	RulePriority     int               `json:"rulePriority"`
+	BypassRoute      *bool             `json:"bypassRoute"`
+	VRF              string            `json:"vrf"`
+	Bandwidth        *Bandwidth        `json:"bandwidth"`
+	VxlanEgressRate  uint64            `json:"vxlanEgressRate"`
+	VxlanEgressBurst uint64            `json:"vxlanEgressBurst"`
+
+	policy  *networkPolicy
+	lockDir string
+}
+
+//GetCidrs returns every cidr configured on the vxlan, starting with the single Cidr if it is set
+func (v *Vxlan) GetCidrs() []string {
+	var cidrs []string
+	if v.Cidr != "" {
+		cidrs = append(cidrs, v.Cidr)
+	}
+	return append(cidrs, v.Cidrs...)
+}
+
+//GetRouteTable returns the table holding the vxlan's bypass routes
+func (v *Vxlan) GetRouteTable() int {
+	if v.RouteTable != 0 {
+		return v.RouteTable
+	}
+	return DefaultVxlanRouteTable
+}
+
+//HasBypassRoute reports whether the vxlan's bypass routes and rules should be installed, they are unless disabled
+func (v *Vxlan) HasBypassRoute() bool {
+	return v.BypassRoute == nil || *v.BypassRoute
 }
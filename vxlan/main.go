@@ -2,12 +2,15 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/TrilliumIT/iputil"
@@ -18,19 +21,18 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 )
 
 func main() {
 	var exitOutput []byte
 	exitCode := 0
-	lf, err := os.OpenFile("/var/log/vxlan.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		exitCode, exitOutput = cni.PrepareExit(err, 99, "failed to open log file")
-		return
-	}
-	defer lf.Close()
-	log.SetOutput(lf)
-	log.SetLevel(log.DebugLevel)
+	cniVersion := cni.CNIVersion
+	var logCloser io.Closer
+	start := time.Now()
+
+	//until the config is parsed, log to stderr where the runtime can capture it
+	log.SetOutput(os.Stderr)
 
 	defer func() {
 		r := recover()
@@ -41,35 +43,106 @@ func main() {
 			}
 			exitCode, exitOutput = cni.PrepareExit(err, 99, "panic during execution")
 		}
+		if exitCode != 0 {
+			exitOutput = setErrorVersion(exitOutput, cniVersion)
+		}
 		log.WithField("stdout", string(exitOutput)).Debug("ouptut")
+		if logCloser != nil {
+			logCloser.Close()
+		}
 		exit(exitCode, exitOutput)
 	}()
 
-	log.WithField("command", os.Getenv("CNI_COMMAND")).Debug()
-	varNames := []string{"CNI_COMMAND", "CNI_CONTAINERID", "CNI_NETNS", "CNI_IFNAME", "CNI_ARGS", "CNI_PATH"}
-	varMap := log.Fields{}
-	for _, vn := range varNames {
-		varMap[vn] = os.Getenv(vn)
-	}
-	log.WithFields(varMap).Debug("vars")
-
 	//Read CNI standard environment variables
 	vars := cni.NewVars()
 
+	//Read and parse STDIN
+	conf, err := parseStdin()
+	if err == nil {
+		var lerr error
+		logCloser, lerr = conf.ConfigureLogging()
+		if lerr != nil {
+			//bad logging config must not fail the command, DEL and GC especially have to run
+			log.SetOutput(os.Stderr)
+			log.WithError(lerr).Warnf("failed to configure logging, logging to stderr")
+		}
+
+		log.WithField("command", vars.Command).Debug()
+		varNames := []string{"CNI_COMMAND", "CNI_CONTAINERID", "CNI_NETNS", "CNI_IFNAME", "CNI_ARGS", "CNI_PATH"}
+		varMap := log.Fields{}
+		for _, vn := range varNames {
+			varMap[vn] = os.Getenv(vn)
+		}
+		log.WithFields(varMap).Debug("vars")
+		log.WithField("config", conf.Redacted()).Debug("parsed stdin json")
+	}
+
 	if vars.Command == "VERSION" {
-		//report supported cni versions
-		exitOutput = []byte(fmt.Sprintf("{\"cniVersion\": \"%v\", \"supportedVersions\": [\"%v\"]}", cni.CNIVersion, cni.CNIVersion))
+		//report supported cni versions, echoing the requested version if there was one
+		if err == nil && conf.CNIVersion != "" {
+			cniVersion = conf.CNIVersion
+		}
+		exitOutput, err = json.Marshal(&vxlan.VersionInfo{
+			CNIVersion:        cniVersion,
+			SupportedVersions: vxlan.SupportedVersions,
+		})
+		if err != nil {
+			exitCode, exitOutput = cni.PrepareExit(err, 99, "failed to marshal version info")
+		}
 		return
 	}
 
-	//Read and parse STDIN
-	log.Debug("parsing stdin json")
-	conf, err := parseStdin()
 	if err != nil {
 		exitCode, exitOutput = cni.PrepareExit(err, 6, "failed to parse STDIN")
 		return
 	}
 
+	if conf.CNIVersion != "" {
+		cniVersion = conf.CNIVersion
+	}
+
+	if !vxlan.IsSupportedVersion(cniVersion) {
+		exitCode, exitOutput = cni.PrepareExit(fmt.Errorf("requested %v, supported %v", cniVersion, vxlan.SupportedVersions), 1, "incompatible CNI version")
+		return
+	}
+
+	//DEL must clean up as much as it can, even if the config has since gone bad
+	if vars.Command != "DEL" {
+		err = conf.Validate()
+		if err != nil {
+			exitCode, exitOutput = cni.PrepareExit(err, 7, "invalid network configuration")
+			return
+		}
+	}
+
+	if conf.Ipam == nil {
+		exitCode, exitOutput = cni.PrepareExit(nil, 7, "no ipam plugin configured")
+		return
+	}
+
+	ipamBin := vars.Path + string(os.PathSeparator) + conf.Ipam.Type
+
+	if vars.Command == "GC" || vars.Command == "STATUS" {
+		if !vxlan.VersionAtLeast(cniVersion, "1.1.0") {
+			exitCode, exitOutput = cni.PrepareExit(fmt.Errorf("%v requires cniVersion 1.1.0 or later, requested %v", vars.Command, cniVersion), 1, "incompatible CNI version")
+			return
+		}
+
+		if vars.Command == "GC" {
+			err = gc(conf, ipamBin, start.Add(conf.LockWait()))
+			if err != nil {
+				exitCode, exitOutput = cni.PrepareExit(err, 11, "failed to garbage collect")
+			}
+			return
+		}
+
+		err = conf.Status(ipamBin)
+		if err != nil {
+			exitCode, exitOutput = cni.PrepareExit(err, 50, "plugin not available")
+		}
+		return
+	}
+
 	if conf.Args == nil {
 		conf.Args = &cni.Args{}
 	}
@@ -92,7 +165,173 @@ func main() {
 		}
 	}
 
+	sels, serr := selectNetworks(conf, vars, namespace)
+
+	inv := &invocation{
+		conf:    conf,
+		vars:    vars,
+		ipamBin: ipamBin,
+		//wait no longer than the runtime will, so a hung invocation holding a lock produces an error rather than a pile of waiters
+		deadline: start.Add(conf.LockWait()),
+	}
+
+	switch vars.Command {
+	case "ADD":
+		if serr != nil {
+			exitCode, exitOutput = serr.prepareExit()
+			return
+		}
+
+		//the firewall covers every interface of the pod
+		var fw *vxlan.Firewall
+		if fwa, ok := conf.Args.Annotations[vxlan.FirewallAnnotation]; ok {
+			fw, err = vxlan.ParseFirewall(fwa)
+			if err != nil {
+				exitCode, exitOutput = cni.PrepareExit(err, 7, "invalid firewall annotation")
+				return
+			}
+		}
+
+		result := &cni.Result{CNIVersion: cniVersion}
+		var mtus []int
+		var statuses []*vxlan.NetworkStatus
+		for i, sel := range sels {
+			a, cerr := inv.add(sel, i == 0, fw)
+			if cerr != nil {
+				//detach the networks already attached, so a failed ADD leaves nothing behind
+				for _, done := range sels[:i] {
+					inv.del(done)
+				}
+				exitCode, exitOutput = cerr.prepareExit()
+				return
+			}
+
+			//the addresses are on the container interface, which follows its network's host interface
+			result.Interfaces = append(result.Interfaces, a.host, a.container)
+			mtus = append(mtus, a.mtu, a.mtu)
+			index := len(result.Interfaces) - 1
+			for _, ip := range a.ips {
+				ip.Interface = &index
+				result.IPs = append(result.IPs, ip)
+			}
+			result.Routes = append(result.Routes, a.routes...)
+			if emptyDNS(result.DNS) {
+				result.DNS = a.dns
+			}
+			statuses = append(statuses, networkStatus(sel, a))
+		}
+
+		if conf.K8sNetworkStatus && nsok && pnok {
+			err = setK8sNetworkStatus(conf.K8sConfigPath, namespace, podname, statuses)
+			if err != nil {
+				log.WithError(err).Errorf("failed to write network status to pod")
+			}
+		}
+
+		exitOutput, err = vxlan.MarshalResult(result, cniVersion, mtus)
+		if err != nil {
+			exitCode, exitOutput = cni.PrepareExit(err, 99, "failed to marshal result")
+		}
+		return
+	case "DEL":
+		//our own records cover every network the container was attached to, even if its annotation has since changed
+		targets := sels
+		if serr != nil {
+			log.Warnf("%v, deleting the attachments recorded for the container", serr)
+		}
+
+		states, err := vxlan.NewStateStore(conf.GetLockDir()).ListContainer(vars.ContainerID)
+		if err != nil {
+			log.WithError(err).Errorf("failed to read attachment state")
+		}
+		for _, state := range states {
+			if selected(targets, state.IfName) {
+				continue
+			}
+			if conf.GetVxlan(state.Network) == nil {
+				log.WithFields(log.Fields{"network": state.Network, "ifName": state.IfName}).Warnf("recorded attachment is to a network no longer configured, skipping")
+				continue
+			}
+			targets = append(targets, &vxlan.NetworkSelection{Name: state.Network, Interface: state.IfName})
+		}
+
+		if len(targets) == 0 && serr != nil {
+			exitCode, exitOutput = serr.prepareExit()
+			return
+		}
+
+		//detach as much as possible, reporting the first failure
+		var first *cniError
+		for _, t := range targets {
+			cerr := inv.del(t)
+			if cerr != nil && first == nil {
+				first = cerr
+			}
+		}
+		if first != nil {
+			exitCode, exitOutput = first.prepareExit()
+		}
+		return
+	case "CHECK":
+		if !vxlan.VersionAtLeast(cniVersion, "0.4.0") {
+			exitCode, exitOutput = cni.PrepareExit(fmt.Errorf("CHECK requires cniVersion 0.4.0 or later, requested %v", cniVersion), 1, "incompatible CNI version")
+			return
+		}
+
+		if serr != nil {
+			exitCode, exitOutput = serr.prepareExit()
+			return
+		}
+
+		for _, sel := range sels {
+			cerr := inv.check(sel)
+			if cerr != nil {
+				exitCode, exitOutput = cerr.prepareExit()
+				return
+			}
+		}
+
+		//success
+		return
+	default:
+		exitCode, exitOutput = cni.PrepareExit(fmt.Errorf("CNI_COMMAND was not set, or set to an invalid value"), 4, "invalid CNI_COMMAND")
+		return
+	}
+}
+
+//cniError is a failure reported to the runtime, with its code and message
+type cniError struct {
+	code int
+	msg  string
+	err  error
+}
+
+func (e *cniError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%v: %v", e.msg, e.err)
+	}
+	return e.msg
+}
+
+func (e *cniError) prepareExit() (int, []byte) {
+	return cni.PrepareExit(e.err, e.code, e.msg)
+}
+
+//selectNetworks returns the networks to attach the pod to, from the NetworkAnnotation, the MultusNetworksAnnotation,
+//or else the single network named after the pod's namespace or the default network
+func selectNetworks(conf *vxlan.Config, vars *cni.Vars, namespace string) ([]*vxlan.NetworkSelection, *cniError) {
 	network, ok := conf.Args.Annotations[vxlan.NetworkAnnotation]
+	if multus, mok := conf.Args.Annotations[vxlan.MultusNetworksAnnotation]; !ok && mok {
+		sel, err := conf.MultusSelection(multus, vars.ContainerInterface)
+		if err != nil {
+			return nil, &cniError{code: 7, msg: "invalid multus network annotation", err: err}
+		}
+		if sel != nil {
+			return configuredSelections(conf, vars, []*vxlan.NetworkSelection{sel})
+		}
+		//no element is this invocation's, so multus is running the plugin for the pod's default network
+	}
+
 	if !ok {
 		//if network is not specified in annotations
 		if conf.K8sNetworkFromNamespace {
@@ -104,137 +343,621 @@ func main() {
 	}
 
 	if network == "" {
-		exitCode, exitOutput = cni.PrepareExit(nil, 7, "no network specified")
-		return
+		return nil, &cniError{code: 7, msg: "no network specified"}
+	}
+
+	sels, err := vxlan.ParseNetworkSelections(network, vars.ContainerInterface)
+	if err != nil {
+		return nil, &cniError{code: 7, msg: "invalid network annotation", err: err}
+	}
+
+	return configuredSelections(conf, vars, sels)
+}
+
+//configuredSelections checks every selected network is configured, and applies the address and mac requests
+//that aren't part of a selection to the first network
+func configuredSelections(conf *vxlan.Config, vars *cni.Vars, sels []*vxlan.NetworkSelection) ([]*vxlan.NetworkSelection, *cniError) {
+	for _, sel := range sels {
+		if conf.GetVxlan(sel.Name) == nil {
+			return nil, &cniError{code: 7, msg: "no matching network configured", err: fmt.Errorf("network %v is not configured", sel.Name)}
+		}
+	}
+
+	if reqAddress, ok := conf.Args.Annotations[vxlan.AddressAnnotation]; ok && len(sels[0].Addresses) == 0 {
+		sels[0].Addresses = strings.Split(reqAddress, ",")
+	}
+
+	if sels[0].MAC == "" {
+		sels[0].MAC = requestedMAC(conf, vars)
 	}
 
-	var vxlp *vxlan.Vxlan
-	for _, v := range conf.Vxlans {
-		if v.Name == network {
-			vxlp = v
-			break
+	for _, sel := range sels {
+		if sel.MAC == "" {
+			continue
+		}
+		err := vxlan.ValidateMAC(sel.MAC, conf.RequireLocalMAC)
+		if err != nil {
+			return nil, &cniError{code: 7, msg: "invalid requested mac", err: fmt.Errorf("network %v: %v", sel.Name, err)}
 		}
 	}
 
+	return sels, nil
+}
+
+//requestedMAC returns the mac requested by the runtime, in its mac capability or the MAC CNI arg, or else by the MACAnnotation
+func requestedMAC(conf *vxlan.Config, vars *cni.Vars) string {
+	if conf.RuntimeConfig != nil && conf.RuntimeConfig.MAC != "" {
+		return conf.RuntimeConfig.MAC
+	}
+	if mac, ok := vars.GetArg("MAC"); ok && mac != "" {
+		return mac
+	}
+	return conf.Args.Annotations[vxlan.MACAnnotation]
+}
+
+//macInUse reports whether mac is already used on the network, by anything other than the attachment on ifname
+func macInUse(hi *vxlan.HostInterface, store *vxlan.StateStore, vars *cni.Vars, network, ifname string, mac net.HardwareAddr) (bool, error) {
+	inUse, err := hi.MACInUse(mac)
+	if err != nil || inUse {
+		return inUse, err
+	}
+
+	states, err := store.ListNetwork(network)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range states {
+		if s.MAC == mac.String() && (s.ContainerID != vars.ContainerID || s.IfName != ifname) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//selected reports whether one of the selections is attached on ifname
+func selected(sels []*vxlan.NetworkSelection, ifname string) bool {
+	for _, sel := range sels {
+		if sel.Interface == ifname {
+			return true
+		}
+	}
+	return false
+}
+
+//invocation is what the work on each of the pod's networks shares
+type invocation struct {
+	conf     *vxlan.Config
+	vars     *cni.Vars
+	ipamBin  string
+	deadline time.Time
+}
+
+//attachment is one of the pod's networks, held under the network's lock until it is closed
+type attachment struct {
+	sel     *vxlan.NetworkSelection
+	vxlp    *vxlan.Vxlan
+	lock    *vxlan.Lock
+	routing *vxlan.RoutingLock
+	refs    *vxlan.RefCount
+	store   *vxlan.StateStore
+	state   *vxlan.AttachmentState
+}
+
+//open locks the selected network, networks are locked one at a time so invocations can't deadlock on each other
+func (inv *invocation) open(sel *vxlan.NetworkSelection) (*attachment, *cniError) {
+	vxlp := inv.conf.GetVxlan(sel.Name)
 	if vxlp == nil {
-		exitCode, exitOutput = cni.PrepareExit(nil, 7, "no matching network configured")
-		return
+		return nil, &cniError{code: 7, msg: "no matching network configured", err: fmt.Errorf("network %v is not configured", sel.Name)}
 	}
 
-	lock, err := vxlan.NewLock(network)
+	lock, err := vxlan.NewLock(inv.conf.GetLockDir(), vxlp.Name)
 	if err != nil {
-		exitCode, exitOutput = cni.PrepareExit(err, 11, "failed to create lock file")
-		return
+		return nil, &cniError{code: 11, msg: "failed to create lock file", err: err}
 	}
 
-	lock.Lock()
-	defer lock.Close()
+	err = lock.TryLock(inv.deadline)
+	if err != nil {
+		lock.Close()
+		log.WithError(err).Errorf("failed to acquire network lock")
+		return nil, &cniError{code: 11, msg: "failed to acquire network lock, try again later", err: err}
+	}
 
-	ipamBin := vars.Path + string(os.PathSeparator) + conf.Ipam.Type
+	//taken after the network lock, it is only held briefly while rules and routes are changed
+	routing, err := vxlan.NewRoutingLock(inv.conf.GetLockDir(), inv.deadline)
+	if err != nil {
+		lock.Close()
+		return nil, &cniError{code: 11, msg: "failed to create lock file", err: err}
+	}
 
-	switch vars.Command {
-	case "ADD":
-		hostAddr, _ := netlink.ParseIPNet(vxlp.Cidr)
-		address := iputil.NetworkID(hostAddr).String()
-
-		reqAddress, ok := conf.Args.Annotations[vxlan.AddressAnnotation]
-		if ok {
-			ip := net.ParseIP(reqAddress)
-			if ip != nil {
-				if hostAddr.Contains(ip) {
-					hostAddr.IP = ip
-					address = hostAddr.String()
-				}
-			}
-		}
+	a := &attachment{
+		sel:     sel,
+		vxlp:    vxlp,
+		lock:    lock,
+		routing: routing,
+		refs:    vxlan.NewRefCount(inv.conf.GetLockDir(), vxlp.Name),
+		store:   vxlan.NewStateStore(inv.conf.GetLockDir()),
+	}
 
-		//get/create host interface
-		hi, err := vxlan.GetOrCreateHostInterface(vxlp)
-		if err != nil {
-			exitCode, exitOutput = cni.PrepareExit(err, 11, "failed to get or create host interface")
-			return
+	a.state, err = a.store.Get(inv.vars.ContainerID, sel.Interface)
+	if err != nil {
+		log.WithError(err).Errorf("failed to read attachment state")
+	}
+
+	return a, nil
+}
+
+//Close releases the network's locks
+func (a *attachment) Close() {
+	a.routing.Close()
+	a.lock.Close()
+}
+
+//attached is the part of the result for one network
+type attached struct {
+	host      *cni.Interface
+	container *cni.Interface
+	mtu       int
+	mac       net.HardwareAddr
+	ips       []*cni.IP
+	routes    []*cni.Route
+	dns       *cni.DNS
+}
+
+//add attaches the pod to the selected network, primary is the pod's first network, which gets the runtime's bandwidth limits
+func (inv *invocation) add(sel *vxlan.NetworkSelection, primary bool, fw *vxlan.Firewall) (*attached, *cniError) {
+	log.WithFields(log.Fields{"network": sel.Name, "ifName": sel.Interface}).Debugf("attaching network")
+	a, cerr := inv.open(sel)
+	if cerr != nil {
+		return nil, cerr
+	}
+	defer a.Close()
+
+	conf, vars, vxlp, ipamBin := inv.conf, inv.vars, a.vxlp, inv.ipamBin
+	state := a.state
+
+	//get/create host interface
+	hi, err := vxlan.GetOrCreateHostInterface(vxlp, a.routing)
+	if err != nil {
+		return nil, &cniError{code: 11, msg: "failed to get or create host interface", err: err}
+	}
+
+	gateways := hi.GetGateways()
+	if len(gateways) == 0 {
+		return nil, &cniError{code: 7, msg: "no valid cidr configured for network"}
+	}
+
+	//the runtime's bandwidth capability overrides the network's defaults
+	var bw *vxlan.Bandwidth
+	if primary && conf.RuntimeConfig != nil {
+		bw = conf.RuntimeConfig.Bandwidth
+	}
+	bw = bw.Merge(vxlp.Bandwidth)
+
+	dsts := hi.ContainerRoutes(sel.HasDefaultRoute(), sel.RouteDestinations())
+
+	//a retried ADD finds the interface it already attached, and returns it rather than allocating again
+	existing, removed, err := hi.ExistingContainerLink(vars.NetworkNamespace, sel.Interface, dsts)
+	if err != nil {
+		return nil, &cniError{code: 11, msg: "failed to inspect existing container interface", err: err}
+	}
+	//without a record, the removed interface's addresses are all that is left of what its ADD allocated
+	if len(removed) > 0 && state == nil {
+		log.WithField("addrs", removed).Warnf("releasing addresses of the removed container interface")
+		var ips []*cni.IP
+		for _, addr := range removed {
+			ips = append(ips, &cni.IP{Version: vxlan.IPVersion(addr.IP), Address: addr.String()})
 		}
+		ipamRelease(ipamBin, ips)
+	}
 
-		result, err := ipamAdd(ipamBin, address, vxlp.ExcludeFirst, vxlp.ExcludeLast)
+	at := &attached{
+		host: &cni.Interface{
+			Name: hi.HostLinkName(),
+		},
+		container: &cni.Interface{
+			Name:    sel.Interface,
+			Sandbox: vars.NetworkNamespace,
+		},
+		mtu: hi.MTU(),
+	}
+	var addrs []*net.IPNet
+	var mac net.HardwareAddr
+	if existing != nil {
+		log.WithField("addrs", existing.Addrs).Infof("container interface is already attached, returning its existing addresses")
+		addrs, mac = existing.Addrs, existing.MAC
+		if state != nil {
+			at.dns = state.DNS
+		}
+		//the ADD that attached it may have failed before applying them
+		err = hi.ReapplyContainerLink(vars.NetworkNamespace, vars.ContainerID, sel.Interface, &vxlan.ContainerLinkOptions{
+			Firewall:  fw,
+			Bandwidth: bw,
+		})
 		if err != nil {
-			exitCode, exitOutput = cni.PrepareExit(err, 11, "failure to get address from IPAM")
-			return
+			return nil, &cniError{code: 11, msg: "failed to apply firewall and bandwidth limits to existing container interface", err: err}
+		}
+		for _, addr := range addrs {
+			at.ips = append(at.ips, &cni.IP{
+				Version: vxlan.IPVersion(addr.IP),
+				Address: addr.String(),
+				Gateway: hi.GetGatewayFor(addr.IP).IP.String(),
+			})
+		}
+	} else {
+		//a record without an interface is from an ADD that didn't complete, or whose interface was removed
+		if state != nil {
+			log.WithField("addresses", state.Addresses).Warnf("container interface missing, releasing addresses from its previous attachment")
+			ipamRelease(ipamBin, state.IPs())
+			state = nil
 		}
 
-		if len(result.IPs) < 1 || result.IPs[0].Address == "" {
-			exitCode, exitOutput = cni.PrepareExit(nil, 11, "no IP was found in ipam result")
-			return
+		reqMAC := sel.HardwareAddr()
+		if reqMAC != nil {
+			inUse, err := macInUse(hi, a.store, vars, vxlp.Name, sel.Interface, reqMAC)
+			if err != nil {
+				return nil, &cniError{code: 11, msg: "failed to check whether the requested mac is in use", err: err}
+			}
+			if inUse {
+				return nil, &cniError{code: 7, msg: "requested mac is already in use", err: fmt.Errorf("mac %v is already in use on network %v", reqMAC, vxlp.Name)}
+			}
 		}
 
-		rAddress := result.IPs[0].Address
-		log.WithField("Address", rAddress).Debugf("ipam returned address")
+		reqAddresses := sel.RequestedAddresses()
 
-		//add cmvl to host interface
-		addr, _ := netlink.ParseIPNet(rAddress)
-		li, err := hi.AddContainerLink(vars.NetworkNamespace, vars.ContainerInterface, addr)
-		if err != nil {
-			exitCode, exitOutput = cni.PrepareExit(err, 11, "failed to add container link to the macvlan bridge")
-			err2 := ipamDel(ipamBin, rAddress)
-			if err2 != nil {
-				log.WithError(err2).Errorf("failure while running ipam delete")
+		//run ipam once per cidr, so dual stack networks get an address from each family
+		for _, gateway := range gateways {
+			ipamResult, err := ipamAdd(ipamBin, requestedAddress(gateway, reqAddresses), vxlp.ExcludeFirst, vxlp.ExcludeLast)
+			if err != nil {
+				ipamRelease(ipamBin, at.ips)
+				return nil, &cniError{code: 11, msg: "failure to get address from IPAM", err: err}
 			}
-			return
+
+			if len(ipamResult.IPs) < 1 || ipamResult.IPs[0].Address == "" {
+				ipamRelease(ipamBin, at.ips)
+				return nil, &cniError{code: 11, msg: "no IP was found in ipam result"}
+			}
+
+			ip := ipamResult.IPs[0]
+			log.WithField("Address", ip.Address).Debugf("ipam returned address")
+
+			addr, err := netlink.ParseIPNet(ip.Address)
+			if err != nil {
+				ipamRelease(ipamBin, append(at.ips, ip))
+				return nil, &cniError{code: 11, msg: "failed to parse address from ipam result", err: err}
+			}
+
+			ip.Version = vxlan.IPVersion(addr.IP)
+			ip.Gateway = gateway.IP.String()
+			at.ips = append(at.ips, ip)
+			if at.dns == nil {
+				at.dns = ipamResult.DNS
+			}
+			addrs = append(addrs, addr)
 		}
 
-		result.Interfaces = append(result.Interfaces, &cni.Interface{
-			Name:    vars.ContainerInterface,
-			Sandbox: vars.NetworkNamespace,
+		//add cmvl to host interface
+		err = hi.AddContainerLink(vars.NetworkNamespace, vars.ContainerID, sel.Interface, addrs, &vxlan.ContainerLinkOptions{
+			MAC:       reqMAC,
+			Routes:    dsts,
+			Firewall:  fw,
+			Bandwidth: bw,
 		})
+		if err != nil {
+			ipamRelease(ipamBin, at.ips)
+			return nil, &cniError{code: 11, msg: "failed to add container link to the macvlan bridge", err: err}
+		}
+
+		mac, err = hi.ContainerLinkMAC(vars.NetworkNamespace, sel.Interface)
+		if err != nil {
+			log.WithError(err).Errorf("failed to get container interface mac")
+		}
+	}
 
-		result.IPs[0].Gateway = hi.GetGateway().IP.String()
-		result.IPs[0].Interface = &li
+	//the network config's dns settings stand in for any ipam didn't return
+	if emptyDNS(at.dns) {
+		at.dns = conf.DNS
+	}
 
-		result.Routes = append(result.Routes, &cni.Route{
-			Destination: "0.0.0.0/0",
-			Gateway:     hi.GetGateway().IP.String(),
+	for _, dst := range dsts {
+		at.routes = append(at.routes, &cni.Route{
+			Destination: dst.String(),
+			Gateway:     hi.GetGatewayFor(dst.IP).IP.String(),
 		})
+	}
 
-		exitOutput = result.Marshal()
-		return
-	case "DEL":
-		log.Debugf("getting host interface")
-		//get/create host interface
-		hi, err := vxlan.GetOrCreateHostInterface(vxlp)
+	_, err = a.refs.Add(vxlan.Attachment{ContainerID: vars.ContainerID, IfName: sel.Interface})
+	if err != nil {
+		log.WithError(err).Errorf("failed to record container in vxlan reference count")
+	}
+
+	if bw.IsLimited() {
+		log.WithFields(log.Fields{
+			"ingressRate": bw.IngressRate,
+			"egressRate":  bw.EgressRate,
+		}).Infof("container interface bandwidth limited")
+	}
+
+	err = saveState(a.store, state, vars, sel.Interface, vxlp, addrs, mac, at.dns, bw)
+	if err != nil {
+		log.WithError(err).Errorf("failed to record attachment state")
+	}
+
+	if conf.NeighborRecordsPath != "" && mac != nil {
+		err = hi.PublishNeighbor(conf.NeighborRecordsPath, mac, addrs)
 		if err != nil {
-			log.WithError(err).Errorf("failed to get or create host interface during DEL")
+			log.WithError(err).Errorf("failed to publish container to neighbor records")
 		}
+	}
 
-		if hi != nil {
-			log.Debugf("deleting container link")
-			//delete cmvl
-			err = hi.DeleteContainerLink(vars.NetworkNamespace, vars.ContainerInterface)
+	hmac, err := hi.HostLinkMAC()
+	if err != nil {
+		log.WithError(err).Errorf("failed to get host interface mac")
+	} else {
+		at.host.MAC = hmac.String()
+	}
+	if mac != nil {
+		at.container.MAC = mac.String()
+	}
+	at.mac = mac
+	return at, nil
+}
+
+//del detaches the pod from the selected network, only failing if the network can't be locked
+func (inv *invocation) del(sel *vxlan.NetworkSelection) *cniError {
+	log.WithFields(log.Fields{"network": sel.Name, "ifName": sel.Interface}).Debugf("detaching network")
+	a, cerr := inv.open(sel)
+	if cerr != nil {
+		return cerr
+	}
+	defer a.Close()
+
+	conf, vars := inv.conf, inv.vars
+
+	log.Debugf("getting host interface")
+	//get host interface, don't recreate it just to tear it down
+	hi, err := vxlan.GetHostInterface(a.vxlp, a.routing)
+	if err != nil {
+		log.WithError(err).Debugf("host interface incomplete during DEL")
+	}
+
+	log.Debugf("deleting container link")
+	//delete cmvl
+	err = hi.DeleteContainerLink(vars.NetworkNamespace, vars.ContainerID, sel.Interface)
+	if err != nil {
+		log.WithError(err).Errorf("failed to delete container link")
+	}
+
+	//our own record of the attachment is preferred, the previous result covers attachments made before it was kept
+	var ips []*cni.IP
+	if a.state != nil {
+		ips = a.state.IPs()
+	} else if conf.PreviousResult != nil {
+		ips = networkIPs(conf.PreviousResult.IPs, hi)
+	}
+
+	if len(ips) > 0 {
+		if conf.NeighborRecordsPath != "" {
+			err = hi.UnpublishNeighbor(conf.NeighborRecordsPath, cniIPs(ips))
 			if err != nil {
-				log.WithError(err).Errorf("failed to delete container link")
+				log.WithError(err).Errorf("failed to remove container from neighbor records")
 			}
 		}
 
-		if conf.PreviousResult != nil && len(conf.PreviousResult.IPs) > 0 && conf.PreviousResult.IPs[0].Address != "" {
-			err = ipamDel(ipamBin, conf.PreviousResult.IPs[0].Address)
+		ipamRelease(inv.ipamBin, ips)
+	} else {
+		log.Warnf("no previous result or attachment state, no addresses to release")
+	}
+
+	err = a.store.Delete(vars.ContainerID, sel.Interface)
+	if err != nil {
+		log.WithError(err).Errorf("failed to remove attachment state")
+	}
+
+	before, err := a.refs.Count()
+	if err != nil {
+		log.WithError(err).Errorf("failed to read vxlan reference count")
+		return nil
+	}
+
+	count, err := a.refs.Remove(vxlan.Attachment{ContainerID: vars.ContainerID, IfName: sel.Interface})
+	if err != nil {
+		log.WithError(err).Errorf("failed to remove container from vxlan reference count")
+		return nil
+	}
+
+	//if last cmvl, delete host interface
+	//an untracked container never drops the count to zero, so it can't tear down a network still in use
+	if before > 0 && count == 0 {
+		log.Debugf("last container removed from vxlan, deleting host interface")
+		err = hi.Delete()
+		if err != nil {
+			log.WithError(err).Errorf("failed to delete host interface")
+		}
+	}
+
+	return nil
+}
+
+//check verifies the pod's attachment to the selected network
+func (inv *invocation) check(sel *vxlan.NetworkSelection) *cniError {
+	a, cerr := inv.open(sel)
+	if cerr != nil {
+		return cerr
+	}
+	defer a.Close()
+
+	conf, vars, state := inv.conf, inv.vars, a.state
+	hi, _ := vxlan.GetHostInterface(a.vxlp, nil)
+
+	var addrs []*net.IPNet
+	var prev []*cni.IP
+	if conf.PreviousResult != nil {
+		prev = networkIPs(conf.PreviousResult.IPs, hi)
+	}
+	if len(prev) > 0 {
+		for _, ip := range prev {
+			addr, err := netlink.ParseIPNet(ip.Address)
 			if err != nil {
-				log.WithError(err).Errorf("failure while running ipam delete")
+				return &cniError{code: 7, msg: "failed to parse address from previous result", err: err}
 			}
+			addrs = append(addrs, addr)
 		}
+	} else if state != nil {
+		addrs = state.IPNets()
+	}
 
-		//success
-		return
-		//TODO:
-		//if last cmvl
-		//delete host interface
-	case "CHECK":
-		return
-		//if all "ADD" steps are correct
-		//exit 0
-		//else
-		//exit error
-	default:
-		exitCode, exitOutput = cni.PrepareExit(fmt.Errorf("CNI_COMMAND was not set, or set to an invalid value"), 4, "invalid CNI_COMMAND")
-		return
+	if len(addrs) == 0 {
+		return &cniError{code: 7, msg: "no previous result or attachment state with an address to check against"}
+	}
+
+	err := hi.Check()
+	if err == nil {
+		err = hi.CheckContainerLink(vars.NetworkNamespace, sel.Interface, addrs, hi.ContainerRoutes(sel.HasDefaultRoute(), sel.RouteDestinations()))
+	}
+	if err == nil && state != nil && state.MAC != "" {
+		err = checkStateMAC(hi, vars.NetworkNamespace, state)
+	}
+
+	if ce, ok := err.(*vxlan.CheckError); ok {
+		return &cniError{code: ce.Code, msg: ce.Message, err: ce.Err}
+	}
+	if err != nil {
+		return &cniError{code: 11, msg: "failed to check container attachment", err: err}
+	}
+
+	return nil
+}
+
+//networkStatus describes the pod's interface on the selected network for the network status annotation
+func networkStatus(sel *vxlan.NetworkSelection, a *attached) *vxlan.NetworkStatus {
+	status := &vxlan.NetworkStatus{
+		Name:      sel.StatusName(),
+		Interface: sel.Interface,
+		Default:   sel.HasDefaultRoute(),
+		DNS:       a.dns,
+	}
+	if a.mac != nil {
+		status.MAC = a.mac.String()
+	}
+	for _, ip := range a.ips {
+		addr, _, err := net.ParseCIDR(ip.Address)
+		if err == nil {
+			status.IPs = append(status.IPs, addr.String())
+		}
 	}
+	return status
+}
+
+//emptyDNS reports whether dns has no settings
+func emptyDNS(dns *cni.DNS) bool {
+	return dns == nil || (len(dns.Nameservers) == 0 && dns.Domain == "" && len(dns.Search) == 0 && len(dns.Options) == 0)
+}
+
+//networkIPs returns those of ips in one of the network's subnets
+func networkIPs(ips []*cni.IP, hi *vxlan.HostInterface) []*cni.IP {
+	var matched []*cni.IP
+	for _, ip := range ips {
+		addr, _, err := net.ParseCIDR(ip.Address)
+		if err != nil {
+			continue
+		}
+		for _, gateway := range hi.GetGateways() {
+			if gateway.Contains(addr) {
+				matched = append(matched, ip)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+//gc removes every attachment not in the config's valid attachments from each network,
+//deletes leftover temporary links and tears down host interfaces no container is using
+func gc(conf *vxlan.Config, ipamBin string, deadline time.Time) error {
+	valid := make(map[vxlan.Attachment]bool)
+	for _, a := range conf.ValidAttachments {
+		valid[*a] = true
+	}
+
+	for _, vxlp := range conf.Vxlans {
+		err := gcNetwork(conf.GetLockDir(), vxlp, valid, ipamBin, deadline)
+		if err != nil {
+			return err
+		}
+	}
+
+	//release addresses held for containers that are gone
+	err := ipamGC(ipamBin, conf.Bytes())
+	if err != nil {
+		log.WithError(err).Errorf("failure while running ipam gc")
+	}
+
+	return nil
+}
+
+func gcNetwork(lockDir string, vxlp *vxlan.Vxlan, valid map[vxlan.Attachment]bool, ipamBin string, deadline time.Time) error {
+	log.WithField("network", vxlp.Name).Debugf("garbage collecting network")
+	lock, err := vxlan.NewLock(lockDir, vxlp.Name)
+	if err != nil {
+		return err
+	}
+
+	err = lock.TryLock(deadline)
+	if err != nil {
+		lock.Close()
+		return err
+	}
+	defer lock.Close()
+
+	routing, err := vxlan.NewRoutingLock(lockDir, deadline)
+	if err != nil {
+		return err
+	}
+	defer routing.Close()
+
+	refs := vxlan.NewRefCount(lockDir, vxlp.Name)
+	before, err := refs.Count()
+	if err != nil {
+		return err
+	}
+
+	count, err := refs.Retain(valid)
+	if err != nil {
+		return err
+	}
+
+	//release what we recorded for attachments the runtime no longer knows about
+	store := vxlan.NewStateStore(lockDir)
+	states, err := store.ListNetwork(vxlp.Name)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		if valid[vxlan.Attachment{ContainerID: state.ContainerID, IfName: state.IfName}] {
+			continue
+		}
+
+		log.WithFields(log.Fields{"containerID": state.ContainerID, "ifName": state.IfName}).Debugf("removing stale attachment state")
+		ipamRelease(ipamBin, state.IPs())
+		err = store.Delete(state.ContainerID, state.IfName)
+		if err != nil {
+			return err
+		}
+	}
+
+	hi, _ := vxlan.GetHostInterface(vxlp, routing)
+	err = hi.DeleteStaleContainerLinks()
+	if err != nil {
+		return err
+	}
+
+	//as in DEL, only tear down a network we know was in use, unless the runtime says nothing is
+	if hi.IsPresent() && count == 0 && (before > 0 || len(valid) == 0) {
+		log.WithField("network", vxlp.Name).Debugf("no containers remain on network, deleting host interface")
+		return hi.Delete()
+	}
+
+	return nil
 }
 
 func parseStdin() (*vxlan.Config, error) {
@@ -248,11 +971,21 @@ func parseStdin() (*vxlan.Config, error) {
 		return nil, fmt.Errorf("no bytes sent on stdin")
 	}
 
-	log.Debug(string(confBytes))
-
 	return vxlan.NewConfig(confBytes)
 }
 
+//setErrorVersion rewrites the cniVersion of a marshaled error to the version requested by the runtime
+func setErrorVersion(output []byte, version string) []byte {
+	cniErr := &cni.Error{}
+	err := json.Unmarshal(output, cniErr)
+	if err != nil {
+		return output
+	}
+
+	cniErr.Version = version
+	return cniErr.Marshal()
+}
+
 func exit(code int, output []byte) {
 	os.Stdout.Write(output)
 	os.Exit(code)
@@ -264,7 +997,7 @@ func ipamAdd(bin, cidr string, xf, xl int) (*cni.Result, error) {
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, bin)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("CNI_ARGS=CIDR=%v;EXCLUDE_FIRST=%v;EXCLUDE_LAST=%v", cidr, xf, xl))
+	cmd.Env = append(os.Environ(), "CNI_COMMAND=ADD", fmt.Sprintf("CNI_ARGS=CIDR=%v;EXCLUDE_FIRST=%v;EXCLUDE_LAST=%v", cidr, xf, xl))
 
 	out, err := cmd.Output()
 	if err != nil {
@@ -284,6 +1017,87 @@ func ipamAdd(bin, cidr string, xf, xl int) (*cni.Result, error) {
 	return result, nil
 }
 
+//requestedAddress returns the cidr to pass to ipam for the gateway's network
+//the network id if no requested address falls within it
+func requestedAddress(gateway *net.IPNet, reqAddresses []net.IP) string {
+	for _, ip := range reqAddresses {
+		if gateway.Contains(ip) {
+			return (&net.IPNet{IP: ip, Mask: gateway.Mask}).String()
+		}
+	}
+
+	return iputil.NetworkID(gateway).String()
+}
+
+//saveState records the attachment on ifname, keeping the creation time of a previous record for a retried ADD
+func saveState(store *vxlan.StateStore, prev *vxlan.AttachmentState, vars *cni.Vars, ifname string, vxlp *vxlan.Vxlan, addrs []*net.IPNet, mac net.HardwareAddr, dns *cni.DNS, bw *vxlan.Bandwidth) error {
+	state := &vxlan.AttachmentState{
+		ContainerID: vars.ContainerID,
+		Netns:       vars.NetworkNamespace,
+		IfName:      ifname,
+		Network:     vxlp.Name,
+		VNI:         vxlp.ID,
+		DNS:         dns,
+	}
+	if bw.IsLimited() {
+		state.Bandwidth = bw
+	}
+	if prev != nil {
+		state.Created = prev.Created
+	}
+	if mac != nil {
+		state.MAC = mac.String()
+	}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, addr.String())
+	}
+
+	return store.Save(state)
+}
+
+//checkStateMAC verifies the container interface is still the one ADD recorded
+func checkStateMAC(hi *vxlan.HostInterface, namespace string, state *vxlan.AttachmentState) error {
+	mac, err := hi.ContainerLinkMAC(namespace, state.IfName)
+	if err != nil {
+		return err
+	}
+
+	if mac.String() != state.MAC {
+		return &vxlan.CheckError{
+			Code:    vxlan.CheckCodeContainerLink,
+			Message: fmt.Sprintf("container interface %v has mac %v, expected %v", state.IfName, mac, state.MAC),
+		}
+	}
+
+	return nil
+}
+
+//cniIPs returns the addresses without their prefix length
+func cniIPs(cips []*cni.IP) []net.IP {
+	var ips []net.IP
+	for _, ip := range cips {
+		addr, _, err := net.ParseCIDR(ip.Address)
+		if err == nil {
+			ips = append(ips, addr)
+		}
+	}
+	return ips
+}
+
+//ipamRelease runs ipam delete for every address, logging failures
+func ipamRelease(bin string, ips []*cni.IP) {
+	for _, ip := range ips {
+		if ip == nil || ip.Address == "" {
+			continue
+		}
+
+		err := ipamDel(bin, ip.Address)
+		if err != nil {
+			log.WithError(err).Errorf("failure while running ipam delete")
+		}
+	}
+}
+
 func ipamDel(bin, cidr string) error {
 	log.Debugf("executing IPAM DEL")
 	//remove /32 route
@@ -291,7 +1105,8 @@ func ipamDel(bin, cidr string) error {
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, bin)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("CNI_ARGS=CIDR=%v", cidr))
+	//addresses are also released during a failed ADD
+	cmd.Env = append(os.Environ(), "CNI_COMMAND=DEL", fmt.Sprintf("CNI_ARGS=CIDR=%v", cidr))
 
 	err := cmd.Run()
 	if err != nil {
@@ -307,17 +1122,41 @@ func ipamDel(bin, cidr string) error {
 	return nil
 }
 
-func getK8sAnnotations(kubeconfig, namespace, podname string) map[string]string {
-	log.WithFields(log.Fields{"namespace": namespace, "podname": podname}).Debugf("getting annotations")
+func ipamGC(bin string, conf []byte) error {
+	log.Debugf("executing IPAM GC")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(vxlan.DefaultIPAMTimeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, bin)
+	cmd.Stdin = bytes.NewReader(conf)
+
+	err := cmd.Run()
+	if err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+func k8sClient(kubeconfig string) (*kubernetes.Clientset, error) {
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	if err != nil {
-		log.WithError(err).Error("failed to get kubernetes config")
-		return map[string]string{}
+		return nil, fmt.Errorf("failed to get kubernetes config: %v", err)
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		log.WithError(err).Error("failed to get kubernetes client")
+		return nil, fmt.Errorf("failed to get kubernetes client: %v", err)
+	}
+
+	return clientset, nil
+}
+
+func getK8sAnnotations(kubeconfig, namespace, podname string) map[string]string {
+	log.WithFields(log.Fields{"namespace": namespace, "podname": podname}).Debugf("getting annotations")
+	clientset, err := k8sClient(kubeconfig)
+	if err != nil {
+		log.WithError(err).Error("failed to connect to kubernetes")
 		return map[string]string{}
 	}
 
@@ -330,3 +1169,33 @@ func getK8sAnnotations(kubeconfig, namespace, podname string) map[string]string
 	log.WithField("annotations", pod.Annotations).Debug("retrieved annotations")
 	return pod.Annotations
 }
+
+//setK8sNetworkStatus merges the status of the pod's interfaces into its network status annotation
+//the pod is updated at the version read, and read again if it changed in between, so statuses written by others aren't lost
+func setK8sNetworkStatus(kubeconfig, namespace, podname string, statuses []*vxlan.NetworkStatus) error {
+	log.WithFields(log.Fields{"namespace": namespace, "podname": podname}).Debugf("setting network status")
+	clientset, err := k8sClient(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	pods := clientset.CoreV1().Pods(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := pods.Get(context.TODO(), podname, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		status, err := vxlan.MergeNetworkStatus(pod.Annotations[vxlan.MultusNetworkStatusAnnotation], statuses)
+		if err != nil {
+			return err
+		}
+
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[vxlan.MultusNetworkStatusAnnotation] = status
+		_, err = pods.Update(context.TODO(), pod, metav1.UpdateOptions{})
+		return err
+	})
+}